@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/vielasis/bitrise-step-build-router-start/bitrise"
+)
+
+const (
+	envArtifactIndex      = "ROUTER_ARTIFACT_INDEX"
+	artifactIndexFileName = "router-artifacts.json"
+)
+
+// collectedArtifact is an artifact of a forked build downloaded to the deploy dir of the parent
+type collectedArtifact struct {
+	Region      string `json:"region"`
+	A2Code      string `json:"alpha_2_code"`
+	Vendor      string `json:"vendor"`
+	BuildType   string `json:"build_type"`
+	BuildSlug   string `json:"build_slug"`
+	BuildNumber int64  `json:"build_number"`
+	Title       string `json:"title"` // as uploaded by the forked build
+	Path        string `json:"path"`
+}
+
+// parseArtifactPatterns splits newline separated title globs, e.g. "*.aab\n*mapping.txt"
+func parseArtifactPatterns(s string) []string {
+	var patterns []string
+	for _, line := range strings.Split(s, "\n") {
+		if pattern := strings.TrimSpace(line); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// validateArtifactPatterns checks that every pattern is a valid glob
+func validateArtifactPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid artifact pattern %s: %s", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchArtifactTitle reports whether the title matches any of the patterns, checked by validateArtifactPatterns
+func matchArtifactTitle(patterns []string, title string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, title); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// artifactFileName prefixes the title with the region, vendor and build type of the build, e.g. AU-GMS-release-app.aab
+func artifactFileName(child *childBuild, title string) string {
+	return joinIgnoreEmpty([]string{child.A2Code, child.Vendor, child.BuildType, filepath.Base(title)}, "-")
+}
+
+// uniqueFileName adds the artifact slug to a file name already taken by another artifact, e.g. AU-GMS-release-app-a2.aab
+func uniqueFileName(taken map[string]bool, name, artifactSlug string) string {
+	if taken[name] {
+		ext := filepath.Ext(name)
+		name = fmt.Sprintf("%s-%s%s", strings.TrimSuffix(name, ext), artifactSlug, ext)
+	}
+	taken[name] = true
+	return name
+}
+
+// collectArtifacts downloads the artifacts of the forked builds with a title matching any of the patterns.
+// An artifact which cannot be listed or downloaded is skipped and its error returned, the others are still collected.
+func collectArtifacts(app bitrise.App, children childBuilds, patterns []string, deployDir string) ([]collectedArtifact, []error) {
+	var artifacts []collectedArtifact
+	var errs []error
+	taken := make(map[string]bool)
+	for _, child := range children {
+		build := bitrise.Build{Slug: child.BuildSlug}
+		list, err := build.GetBuildArtifacts(app)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to list artifacts of build %s: %s", child.BuildSlug, err))
+			continue
+		}
+
+		for _, slug := range list.ArtifactSlugs {
+			// the list has the titles, only the matching artifacts are fetched for their download url
+			if !matchArtifactTitle(patterns, slug.Title) {
+				log.Debugf("Skipping artifact %s of build %s", slug.Title, child.BuildSlug)
+				continue
+			}
+			resp, err := build.GetBuildArtifact(app, slug.ArtifactSlug)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to get artifact %s of build %s: %s", slug.Title, child.BuildSlug, err))
+				continue
+			}
+			artifact := resp.Artifact
+
+			path := filepath.Join(deployDir, uniqueFileName(taken, artifactFileName(child, artifact.Title), slug.ArtifactSlug))
+			if err := artifact.DownloadArtifact(path); err != nil {
+				errs = append(errs, fmt.Errorf("failed to download artifact %s of build %s: %s", artifact.Title, child.BuildSlug, err))
+				continue
+			}
+			log.Printf("- %s %s: %s -> %s", child.Region, child.Vendor, artifact.Title, path)
+
+			artifacts = append(artifacts, collectedArtifact{
+				Region:      child.Region,
+				A2Code:      child.A2Code,
+				Vendor:      child.Vendor,
+				BuildType:   child.BuildType,
+				BuildSlug:   child.BuildSlug,
+				BuildNumber: child.BuildNumber,
+				Title:       artifact.Title,
+				Path:        path,
+			})
+		}
+	}
+	return artifacts, errs
+}
+
+// writeArtifactIndex writes the collected artifacts as JSON to the deploy dir, returning its path
+func writeArtifactIndex(artifacts []collectedArtifact, deployDir string) (string, error) {
+	if artifacts == nil {
+		artifacts = []collectedArtifact{}
+	}
+	b, err := json.MarshalIndent(artifacts, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(deployDir, artifactIndexFileName)
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
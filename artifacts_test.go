@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vielasis/bitrise-step-build-router-start/bitrise"
+)
+
+func Test_collectArtifacts(t *testing.T) {
+	titles := map[string]string{
+		"a1": "app-release.aab",
+		"a2": "mapping.txt",
+		"a3": "app-release.apk",
+		"a4": "mapping.txt",
+	}
+	slug := func(slug string) bitrise.BuildArtifactSlug {
+		return bitrise.BuildArtifactSlug{ArtifactSlug: slug, Title: titles[slug]}
+	}
+	fetched := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+		switch {
+		case parts[0] == "download":
+			_, _ = fmt.Fprintf(w, "content of %s", parts[1])
+		case len(parts) == 6 && parts[5] == "artifacts" && parts[4] == "broken":
+			w.WriteHeader(http.StatusNotFound)
+		case len(parts) == 6 && parts[5] == "artifacts":
+			// the artifacts are listed on two pages
+			response := bitrise.BuildArtifactsResponse{ArtifactSlugs: []bitrise.BuildArtifactSlug{slug("a1"), slug("a2")}}
+			response.Paging.Next = "a3"
+			if req.URL.Query().Get("next") == "a3" {
+				response = bitrise.BuildArtifactsResponse{ArtifactSlugs: []bitrise.BuildArtifactSlug{slug("a3"), slug("a4")}}
+			}
+			_ = json.NewEncoder(w).Encode(response)
+		case len(parts) == 7:
+			fetched++
+			_ = json.NewEncoder(w).Encode(bitrise.BuildArtifactResponse{Artifact: bitrise.BuildArtifact{
+				Title:       titles[parts[6]],
+				DownloadURL: fmt.Sprintf("%s/download/%s-%s", server.URL, parts[4], parts[6]),
+			}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		patterns    string
+		broken      bool
+		wantFiles   map[string]string
+		wantErrs    int
+		wantFetched int // only matching artifacts are fetched for their download url
+	}{
+		{
+			name:     "bundles and mappings",
+			patterns: "*.aab\n*mapping.txt\n",
+			wantFiles: map[string]string{
+				"AU-GMS-release-app-release.aab": "content of au-a1",
+				"AU-GMS-release-mapping.txt":     "content of au-a2",
+				"AU-GMS-release-mapping-a4.txt":  "content of au-a4",
+				"JP-GMS-release-app-release.aab": "content of jp-a1",
+				"JP-GMS-release-mapping.txt":     "content of jp-a2",
+				"JP-GMS-release-mapping-a4.txt":  "content of jp-a4",
+			},
+			wantFetched: 6,
+		},
+		{
+			name:      "nothing matches",
+			patterns:  "*.ipa",
+			wantFiles: map[string]string{},
+		},
+		{
+			name:     "build without artifacts",
+			patterns: "*.apk",
+			broken:   true,
+			wantFiles: map[string]string{
+				"AU-GMS-release-app-release.apk": "content of au-a3",
+				"JP-GMS-release-app-release.apk": "content of jp-a3",
+			},
+			wantErrs:    1,
+			wantFetched: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "router-artifacts")
+			require.NoError(t, err)
+			defer func() {
+				require.NoError(t, os.RemoveAll(dir))
+			}()
+
+			var children childBuilds
+			children.add(BuildParams{BuildRegion: "Australia", Alpha2Code: "AU", VendorService: "GMS", BuildTypeName: "release"}, bitrise.StartResponse{BuildSlug: "au"})
+			if tt.broken {
+				children.add(BuildParams{BuildRegion: "Indonesia", Alpha2Code: "ID", VendorService: "GMS", BuildTypeName: "release"}, bitrise.StartResponse{BuildSlug: "broken"})
+			}
+			children.add(BuildParams{BuildRegion: "Japan", Alpha2Code: "JP", VendorService: "GMS", BuildTypeName: "release"}, bitrise.StartResponse{BuildSlug: "jp"})
+			app := bitrise.App{BaseURL: server.URL, Slug: "app", AccessToken: "token", IsDebugRetryTimings: true}
+
+			fetched = 0
+			artifacts, errs := collectArtifacts(app, children, parseArtifactPatterns(tt.patterns), dir)
+			require.Len(t, errs, tt.wantErrs, "collectArtifacts() errs")
+			require.Equal(t, tt.wantFetched, fetched, "collectArtifacts() fetched artifacts")
+			require.Len(t, artifacts, len(tt.wantFiles), "collectArtifacts()")
+
+			indexPath, err := writeArtifactIndex(artifacts, dir)
+			require.NoError(t, err, "writeArtifactIndex() err")
+
+			files := map[string]string{}
+			for _, artifact := range artifacts {
+				b, err := ioutil.ReadFile(artifact.Path)
+				require.NoError(t, err)
+				files[filepath.Base(artifact.Path)] = string(b)
+			}
+			require.Equal(t, tt.wantFiles, files, "collectArtifacts() files")
+
+			b, err := ioutil.ReadFile(indexPath)
+			require.NoError(t, err)
+			var index []collectedArtifact
+			require.NoError(t, json.Unmarshal(b, &index))
+			require.Equal(t, len(artifacts), len(index), "writeArtifactIndex()")
+		})
+	}
+}
+
+func Test_validateArtifactPatterns(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns string
+		wantErr  bool
+	}{
+		{name: "no patterns", patterns: ""},
+		{name: "globs", patterns: "*.aab\n*mapping.txt"},
+		{name: "malformed pattern", patterns: "*.aab\n[", wantErr: true},
+		{name: "malformed after a wildcard", patterns: "*[", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateArtifactPatterns(parseArtifactPatterns(tt.patterns))
+			if tt.wantErr {
+				require.Error(t, err, "validateArtifactPatterns() expected to return error")
+				return
+			}
+			require.NoError(t, err, "validateArtifactPatterns() err")
+		})
+	}
+}
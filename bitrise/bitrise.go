@@ -2,12 +2,17 @@ package bitrise
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,20 +20,165 @@ import (
 	"github.com/hashicorp/go-retryablehttp"
 )
 
+// BuildStatus ...
+type BuildStatus int
+
+// statuses of a build as returned by the API
+const (
+	BuildStatusUnknown            BuildStatus = -1 // not polled yet
+	BuildStatusRunning            BuildStatus = 0
+	BuildStatusSuccess            BuildStatus = 1
+	BuildStatusFailed             BuildStatus = 2
+	BuildStatusAborted            BuildStatus = 3
+	BuildStatusAbortedWithSuccess BuildStatus = 4
+)
+
+// String implements fmt.Stringer.
+func (status BuildStatus) String() string {
+	switch status {
+	case BuildStatusUnknown:
+		return "unknown"
+	case BuildStatusRunning:
+		return "running"
+	case BuildStatusSuccess:
+		return "success"
+	case BuildStatusFailed:
+		return "failed"
+	case BuildStatusAborted:
+		return "aborted"
+	case BuildStatusAbortedWithSuccess:
+		return "aborted-with-success"
+	default:
+		return fmt.Sprintf("status-%d", int(status))
+	}
+}
+
+// Finished reports whether the build stopped running
+func (status BuildStatus) Finished() bool {
+	return status != BuildStatusUnknown && status != BuildStatusRunning
+}
+
+// Succeeded reports whether the build finished without failing
+func (status BuildStatus) Succeeded() bool {
+	return status == BuildStatusSuccess || status == BuildStatusAbortedWithSuccess
+}
+
 // Build ...
 type Build struct {
 	Slug                string          `json:"slug"`
-	Status              int             `json:"status"`
+	Status              BuildStatus     `json:"status"`
 	StatusText          string          `json:"status_text"`
 	BuildNumber         int64           `json:"build_number"`
 	TriggeredWorkflow   string          `json:"triggered_workflow"`
+	Branch              string          `json:"branch"`
+	Tag                 string          `json:"tag"`
+	CommitHash          string          `json:"commit_hash"`
+	TriggeredAt         time.Time       `json:"triggered_at"`
+	StartedOnWorkerAt   *time.Time      `json:"started_on_worker_at"` // nil until a worker picks the build up
+	FinishedAt          *time.Time      `json:"finished_at"`          // nil while the build runs
 	OriginalBuildParams json.RawMessage `json:"original_build_params"`
 }
 
+// Environments returns the envs the build was started with
+func (build Build) Environments() map[string]string {
+	var params struct {
+		Environments []Environment `json:"environments"`
+	}
+	if err := json.Unmarshal(build.OriginalBuildParams, &params); err != nil {
+		return nil
+	}
+	envs := make(map[string]string)
+	for _, env := range params.Environments {
+		envs[env.MappedTo] = env.Value
+	}
+	return envs
+}
+
 type buildResponse struct {
 	Data Build `json:"data"`
 }
 
+type paging struct {
+	TotalItemCount int    `json:"total_item_count"`
+	PageItemLimit  int    `json:"page_item_limit"`
+	Next           string `json:"next"` // slug of the first build of the next page, empty on the last page
+}
+
+type buildListResponse struct {
+	Data   []Build `json:"data"`
+	Paging paging  `json:"paging"`
+}
+
+type workflowListResponse struct {
+	Data []string `json:"data"`
+}
+
+// ListBuildsParams filters the builds listed by ListBuilds, zero values match every build
+type ListBuildsParams struct {
+	Workflow        string
+	Branch          string
+	Tag             string
+	CommitHash      string
+	Status          *BuildStatus
+	TriggeredAfter  time.Time
+	TriggeredBefore time.Time
+	Envs            map[string]string // envs the build was started with
+	Limit           int               // builds returned, 0 walks every page
+	PageSize        int               // builds per request, defaults to 50
+	MaxPages        int               // requests made, 0 walks every page
+}
+
+func (params ListBuildsParams) query(next string) url.Values {
+	query := url.Values{}
+	if params.Workflow != "" {
+		query.Set("workflow", params.Workflow)
+	}
+	if params.Branch != "" {
+		query.Set("branch", params.Branch)
+	}
+	if params.Status != nil {
+		query.Set("status", strconv.Itoa(int(*params.Status)))
+	}
+	if !params.TriggeredAfter.IsZero() {
+		query.Set("after", strconv.FormatInt(params.TriggeredAfter.Unix(), 10))
+	}
+	if !params.TriggeredBefore.IsZero() {
+		query.Set("before", strconv.FormatInt(params.TriggeredBefore.Unix(), 10))
+	}
+	pageSize := params.PageSize
+	if pageSize <= 0 {
+		pageSize = 50
+	}
+	query.Set("limit", strconv.Itoa(pageSize))
+	if next != "" {
+		query.Set("next", next)
+	}
+	return query
+}
+
+// matches checks the filters the API cannot apply, and the others again
+func (params ListBuildsParams) matches(build Build) bool {
+	switch {
+	case params.Workflow != "" && build.TriggeredWorkflow != params.Workflow,
+		params.Branch != "" && build.Branch != params.Branch,
+		params.Tag != "" && build.Tag != params.Tag,
+		params.CommitHash != "" && build.CommitHash != params.CommitHash,
+		params.Status != nil && build.Status != *params.Status,
+		!params.TriggeredAfter.IsZero() && build.TriggeredAt.Before(params.TriggeredAfter),
+		!params.TriggeredBefore.IsZero() && build.TriggeredAt.After(params.TriggeredBefore):
+		return false
+	}
+	if len(params.Envs) > 0 {
+		envs := build.Environments()
+		for key, value := range params.Envs {
+			if envs[key] != value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 type hookInfo struct {
 	Type string `json:"type"`
 }
@@ -40,8 +190,8 @@ type startRequest struct {
 
 // StartResponse ...
 type StartResponse struct {
-	Status            string `json:"message"`
-	Message           string `json:"status"`
+	Status            string `json:"status"`
+	Message           string `json:"message"`
 	BuildSlug         string `json:"build_slug"`
 	BuildNumber       int    `json:"build_number"`
 	BuildURL          string `json:"build_url"`
@@ -57,11 +207,13 @@ type buildAbortParams struct {
 // BuildArtifactsResponse ...
 type BuildArtifactsResponse struct {
 	ArtifactSlugs []BuildArtifactSlug `json:"data"`
+	Paging        paging              `json:"paging"`
 }
 
 // BuildArtifactSlug ...
 type BuildArtifactSlug struct {
 	ArtifactSlug string `json:"slug"`
+	Title        string `json:"title"`
 }
 
 // BuildArtifactResponse ...
@@ -138,54 +290,175 @@ func NewRetryableClient(isDebugRetryTimings bool) *retryablehttp.Client {
 	return client
 }
 
-// GetBuild ...
-func (app App) GetBuild(buildSlug string) (build Build, err error) {
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/v0.1/apps/%s/builds/%s", app.BaseURL, app.Slug, buildSlug), nil)
-	if err != nil {
-		return Build{}, err
+// APIError is returned for failed requests to the Bitrise API
+type APIError struct {
+	Method     string
+	Endpoint   string // URL of the request
+	StatusCode int    // 0 if no response was received
+	Body       string
+	Attempts   int // requests sent, including retries
+	Err        error
+}
+
+// Error implements builtin errors.Error.
+func (e *APIError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("%s %s failed after %d attempts: %s", e.Method, e.Endpoint, e.Attempts, e.Err)
+	}
+	msg := fmt.Sprintf("%s %s failed after %d attempts, statuscode: %d, body: %s", e.Method, e.Endpoint, e.Attempts, e.StatusCode, e.Body)
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s, error: %s", msg, e.Err)
 	}
+	return msg
+}
+
+// Unwrap returns the underlying error, if any.
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// IsStatus reports whether err is an *APIError with the given status code, e.g. http.StatusNotFound
+func IsStatus(err error, statusCode int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == statusCode
+}
+
+// request sends an authorized request to the API, encoding body and decoding the response into out.
+// Every failure is returned as an *APIError.
+func (app App) request(method, path string, body interface{}, out interface{}) error {
+	return app.requestWithContext(context.Background(), method, path, body, out)
+}
 
+// requestWithContext is request cancelled with the context, including the waits between retries
+func (app App) requestWithContext(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	_, err := app.send(ctx, method, path, body, out)
+	return err
+}
+
+func (app App) endpoint(path string) string {
+	return fmt.Sprintf("%s/v0.1/apps/%s%s", app.BaseURL, app.Slug, path)
+}
+
+// send is requestWithContext also returning the exchange on success,
+// as an *APIError to fill in if the response turns out to be unusable
+func (app App) send(ctx context.Context, method, path string, body interface{}, out interface{}) (*APIError, error) {
+	apiErr := &APIError{Method: method, Endpoint: app.endpoint(path)}
+	fail := func(err error) (*APIError, error) {
+		apiErr.Err = err
+		return apiErr, apiErr
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fail(fmt.Errorf("failed to encode request: %s", err))
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, apiErr.Endpoint, reqBody)
+	if err != nil {
+		return fail(err)
+	}
 	req.Header.Add("Authorization", "token "+app.AccessToken)
 
 	retryReq, err := retryablehttp.FromRequest(req)
 	if err != nil {
-		return Build{}, fmt.Errorf("failed to create retryable request: %s", err)
+		return fail(fmt.Errorf("failed to create retryable request: %s", err))
 	}
 
 	client := NewRetryableClient(app.IsDebugRetryTimings)
+	client.RequestLogHook = func(_ retryablehttp.Logger, _ *http.Request, attempt int) {
+		apiErr.Attempts = attempt + 1
+	}
 
 	resp, err := client.Do(retryReq)
 	if err != nil {
-		return Build{}, err
+		return fail(err)
 	}
-
 	defer func() {
-		if cerr := resp.Body.Close(); cerr != nil && err == nil {
-			err = cerr
+		if err := resp.Body.Close(); err != nil {
+			log.Warnf("Failed to close response body, error: %s", err)
 		}
 	}()
 
+	apiErr.StatusCode = resp.StatusCode
 	respBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return Build{}, err
+		return fail(fmt.Errorf("failed to read response: %s", err))
 	}
+	apiErr.Body = string(respBody)
 
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return Build{}, fmt.Errorf("failed to get response, statuscode: %d, body: %s", resp.StatusCode, respBody)
+		return apiErr, apiErr
+	}
+	if out == nil {
+		return apiErr, nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fail(fmt.Errorf("failed to decode response: %s", err))
+	}
+	return apiErr, nil
+}
+
+// GetBuild ...
+func (app App) GetBuild(buildSlug string) (Build, error) {
+	return app.GetBuildWithContext(context.Background(), buildSlug)
+}
+
+// GetBuildWithContext is GetBuild cancelled with the context
+func (app App) GetBuildWithContext(ctx context.Context, buildSlug string) (Build, error) {
+	var response buildResponse
+	if err := app.requestWithContext(ctx, http.MethodGet, "/builds/"+buildSlug, nil, &response); err != nil {
+		return Build{}, err
 	}
+	return response.Data, nil
+}
 
-	var buildResponse buildResponse
-	if err := json.Unmarshal(respBody, &buildResponse); err != nil {
-		return Build{}, fmt.Errorf("failed to decode response, body: %s, error: %s", respBody, err)
+// ListBuilds returns the builds of the app matching the params, newest first, walking the pages of the API
+func (app App) ListBuilds(params ListBuildsParams) ([]Build, error) {
+	var builds []Build
+	next := ""
+	for page := 1; ; page++ {
+		var response buildListResponse
+		if err := app.request(http.MethodGet, "/builds?"+params.query(next).Encode(), nil, &response); err != nil {
+			return nil, err
+		}
+		for _, build := range response.Data {
+			if !params.matches(build) {
+				continue
+			}
+			builds = append(builds, build)
+			if params.Limit > 0 && len(builds) == params.Limit {
+				return builds, nil
+			}
+		}
+		if response.Paging.Next == "" || response.Paging.Next == next || (params.MaxPages > 0 && page == params.MaxPages) {
+			return builds, nil
+		}
+		next = response.Paging.Next
 	}
-	return buildResponse.Data, nil
+}
+
+// ListWorkflows returns the workflow IDs of the app
+func (app App) ListWorkflows() ([]string, error) {
+	var response workflowListResponse
+	if err := app.request(http.MethodGet, "/build-workflows", nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Data, nil
 }
 
 // StartBuild ...
-func (app App) StartBuild(workflow string, buildParams json.RawMessage, buildNumber string, environments []Environment) (startResponse StartResponse, err error) {
+func (app App) StartBuild(workflow string, buildParams json.RawMessage, buildNumber string, environments []Environment) (StartResponse, error) {
+	fail := func(err error) (StartResponse, error) {
+		return StartResponse{}, &APIError{Method: http.MethodPost, Endpoint: app.endpoint("/builds"), Err: err}
+	}
+
 	var params map[string]interface{}
 	if err := json.Unmarshal(buildParams, &params); err != nil {
-		return StartResponse{}, err
+		return fail(fmt.Errorf("failed to decode build params: %s", err))
 	}
 	params["workflow_id"] = workflow
 	params["skip_git_status_report"] = true
@@ -200,142 +473,70 @@ func (app App) StartBuild(workflow string, buildParams json.RawMessage, buildNum
 
 	b, err := json.Marshal(params)
 	if err != nil {
-		return StartResponse{}, nil
-	}
-
-	rm := startRequest{HookInfo: hookInfo{Type: "bitrise"}, BuildParams: b}
-	b, err = json.Marshal(rm)
-	if err != nil {
-		return StartResponse{}, nil
+		return fail(fmt.Errorf("failed to encode build params: %s", err))
 	}
 
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/v0.1/apps/%s/builds", app.BaseURL, app.Slug), bytes.NewReader(b))
-	if err != nil {
-		return StartResponse{}, nil
-	}
-	req.Header.Add("Authorization", "token "+app.AccessToken)
-
-	retryReq, err := retryablehttp.FromRequest(req)
-	if err != nil {
-		return StartResponse{}, fmt.Errorf("failed to create retryable request: %s", err)
-	}
-
-	retryClient := NewRetryableClient(app.IsDebugRetryTimings)
-
-	resp, err := retryClient.Do(retryReq)
-	if err != nil {
-		return StartResponse{}, nil
-	}
-
-	defer func() {
-		if cerr := resp.Body.Close(); cerr != nil && err == nil {
-			err = cerr
-		}
-	}()
-
-	respBody, err := ioutil.ReadAll(resp.Body)
+	var response StartResponse
+	exchange, err := app.send(context.Background(), http.MethodPost, "/builds", startRequest{HookInfo: hookInfo{Type: "bitrise"}, BuildParams: b}, &response)
 	if err != nil {
-		return StartResponse{}, nil
+		return StartResponse{}, err
 	}
-
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return StartResponse{}, fmt.Errorf("failed to get response, statuscode: %d, body: %s", resp.StatusCode, respBody)
-	}
-
-	var response StartResponse
-	if err := json.Unmarshal(respBody, &response); err != nil {
-		return StartResponse{}, fmt.Errorf("failed to decode response, body: %s, error: %s", respBody, err)
+	if response.BuildSlug == "" {
+		exchange.Err = fmt.Errorf("build of %s was not started, status: %s, message: %s", workflow, response.Status, response.Message)
+		return StartResponse{}, exchange
 	}
 	return response, nil
 }
 
 // GetBuildArtifacts ...
 func (build Build) GetBuildArtifacts(app App) (BuildArtifactsResponse, error) {
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/v0.1/apps/%s/builds/%s/artifacts", app.BaseURL, app.Slug, build.Slug), nil)
-	if err != nil {
-		return BuildArtifactsResponse{}, nil
-	}
-	req.Header.Add("Authorization", "token "+app.AccessToken)
-
-	retryReq, err := retryablehttp.FromRequest(req)
-	if err != nil {
-		return BuildArtifactsResponse{}, fmt.Errorf("failed to create retryable request: %s", err)
-	}
-
-	retryClient := NewRetryableClient(app.IsDebugRetryTimings)
-
-	resp, err := retryClient.Do(retryReq)
-	if err != nil {
-		return BuildArtifactsResponse{}, nil
-	}
-
-	defer func() {
-		if cerr := resp.Body.Close(); cerr != nil && err == nil {
-			err = cerr
+	var artifacts BuildArtifactsResponse
+	next := ""
+	for {
+		path := fmt.Sprintf("/builds/%s/artifacts", build.Slug)
+		if next != "" {
+			path += "?" + url.Values{"next": {next}}.Encode()
 		}
-	}()
-
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return BuildArtifactsResponse{}, nil
-	}
-
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return BuildArtifactsResponse{}, fmt.Errorf("failed to get response, statuscode: %d, body: %s", resp.StatusCode, respBody)
-	}
-
-	var response BuildArtifactsResponse
-	if err := json.Unmarshal(respBody, &response); err != nil {
-		return BuildArtifactsResponse{}, fmt.Errorf("failed to decode response, body: %s, error: %s", respBody, err)
+		var response BuildArtifactsResponse
+		if err := app.request(http.MethodGet, path, nil, &response); err != nil {
+			return BuildArtifactsResponse{}, err
+		}
+		artifacts.ArtifactSlugs = append(artifacts.ArtifactSlugs, response.ArtifactSlugs...)
+		if response.Paging.Next == "" || response.Paging.Next == next {
+			return artifacts, nil
+		}
+		next = response.Paging.Next
 	}
-	return response, nil
 }
 
 // GetBuildArtifact ...
 func (build Build) GetBuildArtifact(app App, artifactSlug string) (BuildArtifactResponse, error) {
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/v0.1/apps/%s/builds/%s/artifacts/%s", app.BaseURL, app.Slug, build.Slug, artifactSlug), nil)
-	if err != nil {
-		return BuildArtifactResponse{}, nil
-	}
-	req.Header.Add("Authorization", "token "+app.AccessToken)
-
-	retryReq, err := retryablehttp.FromRequest(req)
-	if err != nil {
-		return BuildArtifactResponse{}, fmt.Errorf("failed to create retryable request: %s", err)
+	var response BuildArtifactResponse
+	if err := app.request(http.MethodGet, fmt.Sprintf("/builds/%s/artifacts/%s", build.Slug, artifactSlug), nil, &response); err != nil {
+		return BuildArtifactResponse{}, err
 	}
+	return response, nil
+}
 
-	retryClient := NewRetryableClient(app.IsDebugRetryTimings)
-
-	resp, err := retryClient.Do(retryReq)
+// DownloadArtifact ...
+func (artifact BuildArtifact) DownloadArtifact(filepath string) error {
+	out, err := os.Create(filepath)
 	if err != nil {
-		return BuildArtifactResponse{}, nil
+		return err
 	}
 
 	defer func() {
-		if cerr := resp.Body.Close(); cerr != nil && err == nil {
-			err = cerr
+		if err := out.Close(); err != nil {
+			fmt.Println("Failed to close output stream, error:", err)
 		}
 	}()
 
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return BuildArtifactResponse{}, nil
-	}
-
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return BuildArtifactResponse{}, fmt.Errorf("failed to get response, statuscode: %d, body: %s", resp.StatusCode, respBody)
-	}
-
-	var response BuildArtifactResponse
-	if err := json.Unmarshal(respBody, &response); err != nil {
-		return BuildArtifactResponse{}, fmt.Errorf("failed to decode response, body: %s, error: %s", respBody, err)
-	}
-	return response, nil
+	return download(artifact.DownloadURL, out)
 }
 
-// DownloadArtifact ...
-func (artifact BuildArtifact) DownloadArtifact(filepath string) error {
-	resp, err := http.Get(artifact.DownloadURL)
+// download writes the content of a pre-signed URL, e.g. of an artifact or an archived log, to w
+func download(downloadURL string, w io.Writer) error {
+	resp, err := http.Get(downloadURL)
 	if err != nil {
 		return err
 	}
@@ -346,107 +547,232 @@ func (artifact BuildArtifact) DownloadArtifact(filepath string) error {
 		}
 	}()
 
-	out, err := os.Create(filepath)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return &APIError{Method: http.MethodGet, Endpoint: resp.Request.URL.Scheme + "://" + resp.Request.URL.Host + resp.Request.URL.Path, StatusCode: resp.StatusCode, Body: string(body), Attempts: 1}
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// BuildLog ...
+type BuildLog struct {
+	IsArchived        bool       `json:"is_archived"`
+	ExpiringRawLogURL string     `json:"expiring_raw_log_url"` // full log, once archived
+	LogChunks         []LogChunk `json:"log_chunks"`           // live log, until archived
+}
+
+// LogChunk ...
+type LogChunk struct {
+	Chunk    string `json:"chunk"`
+	Position int    `json:"position"`
+}
+
+// GetBuildLog returns the log info of a build, with the chunks of the live log
+func (app App) GetBuildLog(buildSlug string) (BuildLog, error) {
+	var buildLog BuildLog
+	if err := app.request(http.MethodGet, fmt.Sprintf("/builds/%s/log", buildSlug), nil, &buildLog); err != nil {
+		return BuildLog{}, err
+	}
+	return buildLog, nil
+}
+
+// FetchBuildLog returns the full log of a build, downloading the archived log
+// or joining the chunks of the live log if it is not archived yet
+func (app App) FetchBuildLog(buildSlug string) (string, error) {
+	buildLog, err := app.GetBuildLog(buildSlug)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	defer func() {
-		if err := out.Close(); err != nil {
-			fmt.Println("Failed to close output stream, error:", err)
+	if buildLog.IsArchived && buildLog.ExpiringRawLogURL != "" {
+		var b strings.Builder
+		if err := download(buildLog.ExpiringRawLogURL, &b); err != nil {
+			return "", fmt.Errorf("failed to download archived log of build %s: %s", buildSlug, err)
 		}
-	}()
+		return b.String(), nil
+	}
 
-	_, err = io.Copy(out, resp.Body)
-	return err
+	chunks := append([]LogChunk{}, buildLog.LogChunks...)
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].Position < chunks[j].Position
+	})
+	var b strings.Builder
+	for _, chunk := range chunks {
+		b.WriteString(chunk.Chunk)
+	}
+	return b.String(), nil
 }
 
 // AbortBuild ...
 func (app App) AbortBuild(buildSlug string, abortReason string) error {
-	b, err := json.Marshal(buildAbortParams{
+	params := buildAbortParams{
 		AbortReason:       abortReason,
 		AbortWithSucces:   false,
-		SkipNotifications: true})
-
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/v0.1/apps/%s/builds/%s/abort", app.BaseURL, app.Slug, buildSlug), bytes.NewReader(b))
-	if err != nil {
-		return nil
+		SkipNotifications: true,
 	}
-	req.Header.Add("Authorization", "token "+app.AccessToken)
+	return app.request(http.MethodPost, fmt.Sprintf("/builds/%s/abort", buildSlug), params, nil)
+}
 
-	retryReq, err := retryablehttp.FromRequest(req)
-	if err != nil {
-		return fmt.Errorf("failed to create retryable request: %s", err)
-	}
+// WaitOptions tells how long and how often WaitForBuilds polls
+type WaitOptions struct {
+	Timeout     time.Duration // overall deadline, 0 waits until the context is done
+	MinInterval time.Duration // polling interval after a status change, defaults to 3s
+	MaxInterval time.Duration // the interval grows up to this while nothing changes, defaults to 1m
+}
 
-	retryClient := NewRetryableClient(app.IsDebugRetryTimings)
+// BuildResult is the last known state of a waited build
+type BuildResult struct {
+	Slug          string        `json:"slug"`
+	BuildNumber   int64         `json:"build_number"`
+	Status        BuildStatus   `json:"status"`
+	QueueDuration time.Duration `json:"queue_duration"` // triggered until picked up by a worker
+	Duration      time.Duration `json:"duration"`       // picked up until finished, or until the wait ended
+	TimedOut      bool          `json:"timed_out"`      // still running when the wait timed out
+}
 
-	resp, err := retryClient.Do(retryReq)
-	if err != nil {
-		return nil
-	}
+// WaitResult is the outcome of WaitForBuilds, the builds in the order they were given
+type WaitResult struct {
+	Builds   []BuildResult `json:"builds"`
+	TimedOut bool          `json:"timed_out"`
+}
 
-	defer func() {
-		if cerr := resp.Body.Close(); cerr != nil && err == nil {
-			err = cerr
+// Failed returns the builds which did not succeed, including the ones still running on timeout
+func (result WaitResult) Failed() []BuildResult {
+	var failed []BuildResult
+	for _, build := range result.Builds {
+		if !build.Status.Succeeded() {
+			failed = append(failed, build)
 		}
-	}()
+	}
+	return failed
+}
 
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil
+func newBuildResult(build Build, now time.Time) BuildResult {
+	result := BuildResult{Slug: build.Slug, BuildNumber: build.BuildNumber, Status: build.Status}
+	if build.StartedOnWorkerAt == nil {
+		return result
+	}
+	if !build.TriggeredAt.IsZero() {
+		result.QueueDuration = build.StartedOnWorkerAt.Sub(build.TriggeredAt)
+	}
+	end := now
+	if build.FinishedAt != nil {
+		end = *build.FinishedAt
 	}
+	result.Duration = end.Sub(*build.StartedOnWorkerAt)
+	return result
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return fmt.Errorf("failed to get response, statuscode: %d, body: %s", resp.StatusCode, respBody)
+// isPermanentError reports whether the API rejected the request in a way polling again does not fix,
+// e.g. an unknown build or a revoked access token
+func isPermanentError(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
 	}
-	return nil
+	return apiErr.StatusCode >= 400 && apiErr.StatusCode < 500 && apiErr.StatusCode != http.StatusTooManyRequests
 }
 
-// WaitForBuilds ...
-func (app App) WaitForBuilds(buildSlugs []string, statusChangeCallback func(build Build)) error {
-	failed := false
-	status := map[string]string{}
-	for {
-		running := 0
+// WaitForBuilds polls the builds until every one of them finished, the timeout is reached or the context is done.
+// The callback is called on every status change, with BuildStatusUnknown as the previous status of the first poll.
+// Failed builds are not errors, see WaitResult.Failed. A timeout returns the result with TimedOut set and no error.
+// A failed poll is logged and polled again on the next tick, unless the API rejected it for good, see isPermanentError.
+func (app App) WaitForBuilds(ctx context.Context, buildSlugs []string, opts WaitOptions, statusChangeCallback func(build Build, previous BuildStatus)) (WaitResult, error) {
+	if opts.MinInterval <= 0 {
+		opts.MinInterval = 3 * time.Second
+	}
+	if opts.MaxInterval < opts.MinInterval {
+		opts.MaxInterval = time.Minute
+		if opts.MaxInterval < opts.MinInterval {
+			opts.MaxInterval = opts.MinInterval
+		}
+	}
+
+	waitCtx := ctx
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	results := make(map[string]BuildResult)
+	status := make(map[string]BuildStatus)
+	for _, buildSlug := range buildSlugs {
+		status[buildSlug] = BuildStatusUnknown
+	}
+	result := func(timedOut bool) WaitResult {
+		waitResult := WaitResult{TimedOut: timedOut}
 		for _, buildSlug := range buildSlugs {
-			build, err := app.GetBuild(buildSlug)
-			if err != nil {
-				return fmt.Errorf("failed to get build info, error: %s", err)
+			buildResult, ok := results[buildSlug]
+			if !ok {
+				buildResult = BuildResult{Slug: buildSlug, Status: BuildStatusUnknown}
 			}
+			buildResult.TimedOut = timedOut && !buildResult.Status.Finished()
+			waitResult.Builds = append(waitResult.Builds, buildResult)
+		}
+		return waitResult
+	}
 
-			if status[buildSlug] != build.StatusText {
-				statusChangeCallback(build)
-				status[buildSlug] = build.StatusText
+	// done ends the wait once the context is done, by the timeout or by the caller
+	done := func() (WaitResult, error) {
+		if ctx.Err() != nil {
+			return result(false), ctx.Err()
+		}
+		return result(true), nil
+	}
+
+	pending := buildSlugs
+	interval := opts.MinInterval
+	for len(pending) > 0 {
+		changed := false
+		var running []string
+		for _, buildSlug := range pending {
+			build, err := app.GetBuildWithContext(waitCtx, buildSlug)
+			if waitCtx.Err() != nil {
+				return done()
 			}
-
-			if build.Status == 0 {
-				running++
+			if isPermanentError(err) {
+				return result(false), fmt.Errorf("failed to get build info, error: %s", err)
+			} else if err != nil {
+				// the build is polled again on the next tick
+				log.Warnf("Failed to get build info of %s, error: %s", buildSlug, err)
+				running = append(running, buildSlug)
 				continue
 			}
-
-			if build.Status != 1 {
-				failed = true
+			results[buildSlug] = newBuildResult(build, time.Now())
+
+			if previous := status[buildSlug]; previous != build.Status {
+				changed = true
+				status[buildSlug] = build.Status
+				if statusChangeCallback != nil {
+					statusChangeCallback(build, previous)
+				}
+			}
+			if !build.Status.Finished() {
+				running = append(running, buildSlug)
 			}
-
-			buildSlugs = remove(buildSlugs, buildSlug)
 		}
-		if running == 0 {
+		pending = running
+		if len(pending) == 0 {
 			break
 		}
-		time.Sleep(time.Second * 3)
-	}
-	if failed {
-		return fmt.Errorf("at least one build failed or aborted")
-	}
-	return nil
-}
 
-func remove(slice []string, what string) (b []string) {
-	for _, s := range slice {
-		if s != what {
-			b = append(b, s)
+		// poll often while builds change, back off while they run
+		if changed {
+			interval = opts.MinInterval
+		} else if interval = interval * 3 / 2; interval > opts.MaxInterval {
+			interval = opts.MaxInterval
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-waitCtx.Done():
+			timer.Stop()
+			return done()
+		case <-timer.C:
 		}
 	}
-	return
+	return result(false), nil
 }
@@ -1,9 +1,17 @@
 package bitrise
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -50,3 +58,383 @@ func TestApp_GetBuild(t *testing.T) {
 		})
 	}
 }
+
+func TestApp_request_errors(t *testing.T) {
+	tests := []struct {
+		name         string
+		statusCode   int
+		body         string
+		call         func(app App) error
+		wantStatus   int
+		wantAttempts int
+	}{
+		{
+			name:         "not found is not retried",
+			statusCode:   http.StatusNotFound,
+			body:         `{"message":"Not Found"}`,
+			call:         func(app App) error { _, err := app.GetBuild("missing"); return err },
+			wantStatus:   http.StatusNotFound,
+			wantAttempts: 1,
+		},
+		{
+			name:         "unauthorized abort",
+			statusCode:   http.StatusUnauthorized,
+			body:         `{"message":"Unauthorized"}`,
+			call:         func(app App) error { return app.AbortBuild("slug", "reason") },
+			wantStatus:   http.StatusUnauthorized,
+			wantAttempts: 1,
+		},
+		{
+			name:         "rate limited start",
+			statusCode:   http.StatusTooManyRequests,
+			body:         `{"message":"Too Many Requests"}`,
+			call:         func(app App) error { _, err := app.StartBuild("wf", []byte(`{}`), "1", nil); return err },
+			wantStatus:   http.StatusTooManyRequests,
+			wantAttempts: 1,
+		},
+		{
+			name:         "server error is retried",
+			statusCode:   http.StatusInternalServerError,
+			body:         `{"message":"Internal Server Error"}`,
+			call:         func(app App) error { _, err := app.ListBuilds(ListBuildsParams{Limit: 10}); return err },
+			wantStatus:   http.StatusInternalServerError,
+			wantAttempts: 4,
+		},
+		{
+			name:         "start without build slug",
+			statusCode:   http.StatusCreated,
+			body:         `{"status":"error","message":"workflow not found"}`,
+			call:         func(app App) error { _, err := app.StartBuild("wf", []byte(`{}`), "1", nil); return err },
+			wantStatus:   http.StatusCreated,
+			wantAttempts: 1,
+		},
+		{
+			name: "start with invalid build params",
+			call: func(app App) error { _, err := app.StartBuild("wf", []byte(`[`), "1", nil); return err },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+				writer.WriteHeader(tt.statusCode)
+				_, _ = writer.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			app := App{BaseURL: server.URL, Slug: "aaa", AccessToken: "bbb", IsDebugRetryTimings: true}
+			err := tt.call(app)
+			require.Error(t, err, "expected to return error")
+
+			var apiErr *APIError
+			require.True(t, errors.As(err, &apiErr), "expected *APIError, got %T", err)
+			require.True(t, IsStatus(err, tt.wantStatus), "IsStatus()")
+			require.Equal(t, tt.wantAttempts, apiErr.Attempts, "APIError.Attempts")
+			require.Equal(t, tt.body, apiErr.Body, "APIError.Body")
+			require.Contains(t, apiErr.Endpoint, server.URL+"/v0.1/apps/aaa/builds", "APIError.Endpoint")
+		})
+	}
+}
+
+func TestApp_ListBuilds(t *testing.T) {
+	triggeredAt := func(day int) time.Time {
+		return time.Date(2020, 8, day, 10, 0, 0, 0, time.UTC)
+	}
+	builds := []Build{
+		{Slug: "b6", BuildNumber: 6, Status: 0, TriggeredWorkflow: "release", Branch: "master", CommitHash: "abc", TriggeredAt: triggeredAt(6),
+			OriginalBuildParams: json.RawMessage(`{"environments":[{"mapped_to":"ALPHA_2_CODE","value":"AU"}]}`)},
+		{Slug: "b5", BuildNumber: 5, Status: 1, TriggeredWorkflow: "release", Branch: "master", CommitHash: "abc", TriggeredAt: triggeredAt(5),
+			OriginalBuildParams: json.RawMessage(`{"environments":[{"mapped_to":"ALPHA_2_CODE","value":"JP"}]}`)},
+		{Slug: "b4", BuildNumber: 4, Status: 2, TriggeredWorkflow: "primary", Tag: "2.4.0-RC1", CommitHash: "def", TriggeredAt: triggeredAt(4)},
+		{Slug: "b3", BuildNumber: 3, Status: 1, TriggeredWorkflow: "release", Branch: "master", CommitHash: "abc", TriggeredAt: triggeredAt(3),
+			OriginalBuildParams: json.RawMessage(`{"environments":[{"mapped_to":"ALPHA_2_CODE","value":"AU"}]}`)},
+		{Slug: "b2", BuildNumber: 2, Status: 1, TriggeredWorkflow: "primary", Branch: "develop", CommitHash: "ghi", TriggeredAt: triggeredAt(2)},
+	}
+
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		requests = append(requests, req.URL.RawQuery)
+		query := req.URL.Query()
+
+		// the fake API filters by workflow, pages by slug
+		var filtered []Build
+		for _, build := range builds {
+			if workflow := query.Get("workflow"); workflow == "" || build.TriggeredWorkflow == workflow {
+				filtered = append(filtered, build)
+			}
+		}
+		start := 0
+		for i, build := range filtered {
+			if build.Slug == query.Get("next") {
+				start = i
+			}
+		}
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		end := start + limit
+		response := buildListResponse{Paging: paging{TotalItemCount: len(filtered), PageItemLimit: limit}}
+		if end < len(filtered) {
+			response.Paging.Next = filtered[end].Slug
+		} else {
+			end = len(filtered)
+		}
+		response.Data = filtered[start:end]
+		require.NoError(t, json.NewEncoder(writer).Encode(response))
+	}))
+	defer server.Close()
+
+	success := BuildStatusSuccess
+	tests := []struct {
+		name         string
+		params       ListBuildsParams
+		want         []string
+		wantRequests int
+	}{
+		{
+			name:         "every page",
+			params:       ListBuildsParams{PageSize: 2},
+			want:         []string{"b6", "b5", "b4", "b3", "b2"},
+			wantRequests: 3,
+		},
+		{
+			name:         "workflow and status",
+			params:       ListBuildsParams{Workflow: "release", Status: &success, PageSize: 2},
+			want:         []string{"b5", "b3"},
+			wantRequests: 2,
+		},
+		{
+			name:         "commit and env",
+			params:       ListBuildsParams{CommitHash: "abc", Envs: map[string]string{"ALPHA_2_CODE": "AU"}, PageSize: 2},
+			want:         []string{"b6", "b3"},
+			wantRequests: 3,
+		},
+		{
+			name:         "branch and time window",
+			params:       ListBuildsParams{Branch: "master", TriggeredAfter: triggeredAt(4), TriggeredBefore: triggeredAt(5), PageSize: 2},
+			want:         []string{"b5"},
+			wantRequests: 3,
+		},
+		{
+			name:         "limit stops paging",
+			params:       ListBuildsParams{Limit: 3, PageSize: 2},
+			want:         []string{"b6", "b5", "b4"},
+			wantRequests: 2,
+		},
+		{
+			name:         "max pages stops paging",
+			params:       ListBuildsParams{CommitHash: "ghi", MaxPages: 2, PageSize: 2},
+			want:         nil,
+			wantRequests: 2,
+		},
+		{
+			name:         "tag",
+			params:       ListBuildsParams{Tag: "2.4.0-RC1", PageSize: 2},
+			want:         []string{"b4"},
+			wantRequests: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests = nil
+			app := App{BaseURL: server.URL, Slug: "aaa", AccessToken: "bbb", IsDebugRetryTimings: true}
+			got, err := app.ListBuilds(tt.params)
+			require.NoError(t, err, "App.ListBuilds() err")
+
+			var slugs []string
+			for _, build := range got {
+				slugs = append(slugs, build.Slug)
+				require.False(t, build.TriggeredAt.IsZero(), "App.ListBuilds() triggered at")
+			}
+			require.Equal(t, tt.want, slugs, "App.ListBuilds()")
+			require.Len(t, requests, tt.wantRequests, "App.ListBuilds() requests")
+		})
+	}
+}
+
+func TestApp_FetchBuildLog(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v0.1/apps/aaa/builds/archived/log":
+			_, _ = writer.Write([]byte(`{"is_archived": true, "expiring_raw_log_url": "` + server.URL + `/raw/archived.log"}`))
+		case "/v0.1/apps/aaa/builds/live/log":
+			_, _ = writer.Write([]byte(`{"is_archived": false, "log_chunks": [{"chunk": "line 2\n", "position": 1}, {"chunk": "line 1\n", "position": 0}]}`))
+		case "/raw/archived.log":
+			_, _ = writer.Write([]byte("full line 1\nfull line 2\n"))
+		default:
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		buildSlug string
+		want      string
+		wantErr   bool
+	}{
+		{name: "archived", buildSlug: "archived", want: "full line 1\nfull line 2\n"},
+		{name: "live chunks in order", buildSlug: "live", want: "line 1\nline 2\n"},
+		{name: "unknown build", buildSlug: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := App{BaseURL: server.URL, Slug: "aaa", AccessToken: "bbb", IsDebugRetryTimings: true}
+			got, err := app.FetchBuildLog(tt.buildSlug)
+			if tt.wantErr {
+				require.Error(t, err, "App.FetchBuildLog() expected to return error")
+				return
+			}
+			require.NoError(t, err, "App.FetchBuildLog() err")
+			require.Equal(t, tt.want, got, "App.FetchBuildLog()")
+		})
+	}
+}
+
+func TestApp_WaitForBuilds(t *testing.T) {
+	// status of every poll of a build, the last one repeats
+	statuses := map[string][]BuildStatus{
+		"ok":      {BuildStatusRunning, BuildStatusRunning, BuildStatusSuccess},
+		"failing": {BuildStatusRunning, BuildStatusFailed},
+		"stuck":   {BuildStatusRunning},
+		"flaky":   {BuildStatusSuccess},
+		"slow":    {BuildStatusRunning},
+	}
+	var mu sync.Mutex
+	polls := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		slug := strings.TrimPrefix(req.URL.Path, "/v0.1/apps/aaa/builds/")
+		sequence, ok := statuses[slug]
+		if !ok {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mu.Lock()
+		poll := polls[slug]
+		polls[slug]++
+		mu.Unlock()
+		switch {
+		case slug == "flaky" && poll < 4:
+			// every attempt of the first poll fails
+			writer.WriteHeader(http.StatusBadGateway)
+			return
+		case slug == "slow":
+			select {
+			case <-req.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		case slug == "flaky":
+			poll -= 4
+		}
+		if poll >= len(sequence) {
+			poll = len(sequence) - 1
+		}
+
+		started := time.Date(2021, 1, 1, 10, 5, 0, 0, time.UTC)
+		build := Build{Slug: slug, BuildNumber: 7, Status: sequence[poll], TriggeredAt: started.Add(-5 * time.Minute), StartedOnWorkerAt: &started}
+		if build.Status.Finished() {
+			finished := started.Add(10 * time.Minute)
+			build.FinishedAt = &finished
+		}
+		_ = json.NewEncoder(writer).Encode(buildResponse{Data: build})
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name         string
+		slugs        []string
+		timeout      time.Duration
+		cancel       bool
+		wantChanges  []string
+		wantStatus   []BuildStatus
+		wantFailed   int
+		wantTimedOut bool
+		wantErr      bool
+	}{
+		{
+			name:        "every build finishes",
+			slugs:       []string{"ok", "failing"},
+			wantChanges: []string{"ok unknown -> running", "failing unknown -> running", "failing running -> failed", "ok running -> success"},
+			wantStatus:  []BuildStatus{BuildStatusSuccess, BuildStatusFailed},
+			wantFailed:  1,
+		},
+		{
+			name:         "timeout",
+			slugs:        []string{"ok", "stuck"},
+			timeout:      50 * time.Millisecond,
+			wantChanges:  []string{"ok unknown -> running", "stuck unknown -> running", "ok running -> success"},
+			wantStatus:   []BuildStatus{BuildStatusSuccess, BuildStatusRunning},
+			wantFailed:   1,
+			wantTimedOut: true,
+		},
+		{
+			name:        "failed poll is retried on the next tick",
+			slugs:       []string{"flaky"},
+			wantChanges: []string{"flaky unknown -> success"},
+			wantStatus:  []BuildStatus{BuildStatusSuccess},
+		},
+		{
+			name:         "timeout during a poll",
+			slugs:        []string{"slow"},
+			timeout:      50 * time.Millisecond,
+			wantStatus:   []BuildStatus{BuildStatusUnknown},
+			wantFailed:   1,
+			wantTimedOut: true,
+		},
+		{
+			name:    "cancelled",
+			slugs:   []string{"stuck"},
+			cancel:  true,
+			wantErr: true,
+		},
+		{
+			name:    "unknown build",
+			slugs:   []string{"missing"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			polls = map[string]int{}
+			mu.Unlock()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+
+			app := App{BaseURL: server.URL, Slug: "aaa", AccessToken: "bbb", IsDebugRetryTimings: true}
+			opts := WaitOptions{Timeout: tt.timeout, MinInterval: time.Millisecond, MaxInterval: 5 * time.Millisecond}
+			var changes []string
+			start := time.Now()
+			got, err := app.WaitForBuilds(ctx, tt.slugs, opts, func(build Build, previous BuildStatus) {
+				changes = append(changes, fmt.Sprintf("%s %s -> %s", build.Slug, previous, build.Status))
+			})
+			// a poll in flight is cancelled with the wait
+			require.Less(t, int64(time.Since(start)), int64(2*time.Second), "App.WaitForBuilds() duration")
+			if tt.wantErr {
+				require.Error(t, err, "App.WaitForBuilds() expected to return error")
+				return
+			}
+			require.NoError(t, err, "App.WaitForBuilds() err")
+			require.Equal(t, tt.wantChanges, changes, "App.WaitForBuilds() status changes")
+			require.Equal(t, tt.wantTimedOut, got.TimedOut, "WaitResult.TimedOut")
+			require.Len(t, got.Failed(), tt.wantFailed, "WaitResult.Failed()")
+
+			var status []BuildStatus
+			for i, build := range got.Builds {
+				require.Equal(t, tt.slugs[i], build.Slug, "BuildResult.Slug")
+				if build.Status != BuildStatusUnknown {
+					require.Equal(t, 5*time.Minute, build.QueueDuration, "BuildResult.QueueDuration")
+				}
+				require.Equal(t, tt.wantTimedOut && !build.Status.Finished(), build.TimedOut, "BuildResult.TimedOut")
+				if build.Status.Finished() {
+					require.Equal(t, 10*time.Minute, build.Duration, "BuildResult.Duration")
+				}
+				status = append(status, build.Status)
+			}
+			require.Equal(t, tt.wantStatus, status, "BuildResult.Status")
+		})
+	}
+}
@@ -0,0 +1,646 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"gopkg.in/yaml.v2"
+)
+
+// routerConfigVersion is the only schema version this step understands
+const routerConfigVersion = 1
+
+// config files looked up in BITRISE_SOURCE_DIR when no explicit path is given
+var defaultRouterConfigFiles = []string{"router.yml", "router.yaml", "router.json"}
+
+// RouterConfig describes every routing decision made by generateBuildParams.
+// JSON files are accepted too, as JSON is a subset of YAML.
+type RouterConfig struct {
+	Version       int              `yaml:"version" json:"version"`
+	DefaultRegion string           `yaml:"default_region" json:"default_region"`
+	Regions       []RegionConfig   `yaml:"regions" json:"regions"`
+	AllExcludes   []string         `yaml:"all_excludes" json:"all_excludes"`
+	Vendors       VendorConfig     `yaml:"vendors" json:"vendors"`
+	BuildTypes    buildTypeConfigs `yaml:"build_types" json:"build_types"`
+	Tag           TagConfig        `yaml:"tag" json:"tag"`
+	Naming        NamingConfig     `yaml:"naming" json:"naming"`
+	Branches      []BranchRule     `yaml:"branches" json:"branches"` // first matching rule routes a branch build
+	Workflows     WorkflowConfig   `yaml:"workflows" json:"workflows"`
+}
+
+// RegionConfig ...
+type RegionConfig struct {
+	Code            string `yaml:"code" json:"code"`                         // matched in tags, e.g. AU
+	Name            string `yaml:"name" json:"name"`                         // used in gradle tasks, e.g. australia
+	Alias           string `yaml:"alias" json:"alias"`                       // exported as ALPHA_2_CODE instead of Code
+	BasePackage     bool   `yaml:"base_package" json:"base_package"`         // package name without the alpha-2 suffix
+	PackageTemplate string `yaml:"package_template" json:"package_template"` // overrides naming.package_template
+	// Vendors and BuildTypes limit what the region ships, empty means everything
+	Vendors    []string `yaml:"vendors" json:"vendors"`
+	BuildTypes []string `yaml:"build_types" json:"build_types"`
+}
+
+// supportsVendor ...
+func (region RegionConfig) supportsVendor(vendor string) bool {
+	if len(region.Vendors) == 0 {
+		return true
+	}
+	for _, v := range region.Vendors {
+		if v == vendor {
+			return true
+		}
+	}
+	return false
+}
+
+// supportsBuildType ...
+func (region RegionConfig) supportsBuildType(buildType BuildType) bool {
+	if len(region.BuildTypes) == 0 {
+		return true
+	}
+	for _, name := range region.BuildTypes {
+		if name == buildType.Name() {
+			return true
+		}
+	}
+	return false
+}
+
+// vendorList is a list of vendors which can also be written as a single vendor
+type vendorList []string
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (l *vendorList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var single string
+	if err := unmarshal(&single); err == nil {
+		*l = vendorList{single}
+		return nil
+	}
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// VendorConfig ...
+type VendorConfig struct {
+	Default   vendorList `yaml:"default" json:"default"` // vendors built when the tag names none, e.g. GMS or [GMS, HMS]
+	Supported []string   `yaml:"supported" json:"supported"`
+	Bundle    []string   `yaml:"bundle" json:"bundle"` // vendors released as app bundles instead of apks
+}
+
+// BuildTypeConfig ...
+type BuildTypeConfig struct {
+	ID                 int    `yaml:"id" json:"id"`                   // exported as BUILD_TYPE
+	GradleName         string `yaml:"gradle_name" json:"gradle_name"` // defaults to the name of the build type
+	Marker             string `yaml:"marker" json:"marker"`           // pattern of the tag token selecting the build type
+	Release            bool   `yaml:"release" json:"release"`         // built as bundles and tagged VERSION-REGION
+	BrowserstackSuffix string `yaml:"browserstack_suffix" json:"browserstack_suffix"`
+	PackageSuffix      string `yaml:"package_suffix" json:"package_suffix"`
+}
+
+// buildTypeConfigs are the build types by name
+type buildTypeConfigs map[string]BuildTypeConfig
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+// An entry only overrides the fields it sets, e.g. overriding the browserstack_suffix of release keeps it a release.
+func (configs *buildTypeConfigs) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var entries map[string]yaml.MapSlice
+	if err := unmarshal(&entries); err != nil {
+		return err
+	}
+
+	merged := make(buildTypeConfigs)
+	for name, buildType := range *configs {
+		merged[name] = buildType
+	}
+	for name, entry := range entries {
+		b, err := yaml.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buildType := merged[name]
+		if err := yaml.UnmarshalStrict(b, &buildType); err != nil {
+			return fmt.Errorf("build type %s: %s", name, err)
+		}
+		merged[name] = buildType
+	}
+	*configs = merged
+	return nil
+}
+
+// BranchRule routes builds of branches matching Pattern, e.g. release/*
+type BranchRule struct {
+	Pattern    string   `yaml:"pattern" json:"pattern"`         // * matches any text, including /
+	BuildType  string   `yaml:"build_type" json:"build_type"`   // defaults to debug
+	Regions    []string `yaml:"regions" json:"regions"`         // used when the branch names no region
+	AllRegions bool     `yaml:"all_regions" json:"all_regions"` // build every region, even for PRs
+	Vendors    []string `yaml:"vendors" json:"vendors"`         // used when the branch names no vendor
+}
+
+// matches reports whether the branch matches the pattern of the rule
+func (rule BranchRule) matches(branch string) bool {
+	pattern := strings.Replace(regexp.QuoteMeta(rule.Pattern), `\*`, ".*", -1)
+	return fullMatch(pattern).MatchString(branch)
+}
+
+// WorkflowConfig routes the builds to workflows
+type WorkflowConfig struct {
+	Default string         `yaml:"default" json:"default"` // defaults to the triggered workflow
+	Rules   []WorkflowRule `yaml:"rules" json:"rules"`     // first matching rule wins
+}
+
+// WorkflowRule runs the builds matching every non empty field in Workflow, e.g. HMS builds in huawei-release
+type WorkflowRule struct {
+	Region    string `yaml:"region" json:"region"`
+	Vendor    string `yaml:"vendor" json:"vendor"`
+	BuildType string `yaml:"build_type" json:"build_type"`
+	Workflow  string `yaml:"workflow" json:"workflow"`
+}
+
+func (rule WorkflowRule) matches(region, vendor string, buildType BuildType) bool {
+	return (rule.Region == "" || rule.Region == region) &&
+		(rule.Vendor == "" || rule.Vendor == vendor) &&
+		(rule.BuildType == "" || rule.BuildType == buildType.Name())
+}
+
+// TagConfig ...
+type TagConfig struct {
+	RCPattern         string `yaml:"rc_pattern" json:"rc_pattern"`
+	PrereleasePattern string `yaml:"prerelease_pattern" json:"prerelease_pattern"` // tokens after the version, e.g. beta.2
+	AllMarker         string `yaml:"all_marker" json:"all_marker"`
+	APKMarker         string `yaml:"apk_marker" json:"apk_marker"`
+	ExcludePrefix     string `yaml:"exclude_prefix" json:"exclude_prefix"` // e.g. NOJP drops JP from an "ALL" build
+}
+
+// NamingConfig ...
+type NamingConfig struct {
+	PackageBase           string            `yaml:"package_base" json:"package_base"`
+	PackageTemplate       string            `yaml:"package_template" json:"package_template"`         // text/template, see packageNameData
+	BuildTaskTemplates    []string          `yaml:"build_task_templates" json:"build_task_templates"` // text/template, see gradleTaskData
+	TestTaskTemplates     []string          `yaml:"test_task_templates" json:"test_task_templates"`
+	VendorPackageSuffixes map[string]string `yaml:"vendor_package_suffixes" json:"vendor_package_suffixes"`
+	GServicesXMLPath      string            `yaml:"gservices_xml_path" json:"gservices_xml_path"`
+	ServicesConfigPaths   map[string]string `yaml:"services_config_paths" json:"services_config_paths"` // per vendor, instead of gservices_xml_path
+}
+
+// defaultRouterConfig returns the rules this step used before they became configurable
+func defaultRouterConfig() RouterConfig {
+	return RouterConfig{
+		Version: routerConfigVersion,
+		Vendors: VendorConfig{
+			Default:   vendorList{"GMS"},
+			Supported: []string{"GMS", "HMS"},
+			Bundle:    []string{"GMS"},
+		},
+		BuildTypes: map[string]BuildTypeConfig{
+			Debug.Name():   {ID: 0, BrowserstackSuffix: "QA", PackageSuffix: "." + Debug.Name()},
+			Qa.Name():      {ID: 1, BrowserstackSuffix: "QA", PackageSuffix: "." + Qa.Name()},
+			Release.Name(): {ID: 2, Release: true, BrowserstackSuffix: "PROD"},
+		},
+		Tag: TagConfig{
+			RCPattern:         `RC\d+`,
+			PrereleasePattern: `(?i)(alpha|beta|dev|preview|rc)(\.?\d+)*`,
+			AllMarker:         "ALL",
+			APKMarker:         "APK",
+			ExcludePrefix:     "NO",
+		},
+		Naming: NamingConfig{
+			PackageBase:        "com.circles.selfcare",
+			PackageTemplate:    defaultPackageTemplate,
+			BuildTaskTemplates: defaultBuildTaskTemplates,
+			TestTaskTemplates:  defaultTestTaskTemplates,
+			GServicesXMLPath:   GSERVICES_XML_FILE_PATH,
+		},
+	}
+}
+
+// parseKeyValueLines parses newline separated KEY=VALUE pairs, keeping their order
+func parseKeyValueLines(s string) ([][2]string, error) {
+	var pairs [][2]string
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		pair := strings.SplitN(line, "=", 2)
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("invalid line %q, expected KEY=VALUE", line)
+		}
+		pairs = append(pairs, [2]string{pair[0], pair[1]})
+	}
+	return pairs, nil
+}
+
+// applyStepInputs fills the regions related settings from the step inputs
+func (rc *RouterConfig) applyStepInputs(cfg Config) error {
+	regions, err := parseKeyValueLines(cfg.SupportedRegions)
+	if err != nil {
+		return fmt.Errorf("supported_regions: %s", err)
+	}
+	aliases, err := parseKeyValueLines(cfg.SupportedRegionsAlias)
+	if err != nil {
+		return fmt.Errorf("supported_regions_alias: %s", err)
+	}
+	aliasMap := make(map[string]string)
+	for _, pair := range aliases {
+		aliasMap[pair[0]] = pair[1]
+	}
+
+	basePackage := make(map[string]bool)
+	for _, line := range strings.Split(cfg.BasePackageRegions, "\n") {
+		if code := strings.TrimSpace(line); code != "" {
+			basePackage[code] = true
+		}
+	}
+
+	rc.Regions = nil
+	for _, pair := range regions {
+		rc.Regions = append(rc.Regions, RegionConfig{
+			Code:        pair[0],
+			Name:        pair[1],
+			Alias:       aliasMap[pair[0]],
+			BasePackage: basePackage[pair[0]],
+		})
+		delete(basePackage, pair[0])
+	}
+	// without supported_regions the regions come from the config file, which ignores base_package_regions
+	if len(regions) > 0 {
+		for code := range basePackage {
+			return fmt.Errorf("base_package_regions: unknown region %s", code)
+		}
+	}
+
+	rc.AllExcludes = nil
+	for _, line := range strings.Split(cfg.AllTagExcludes, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			rc.AllExcludes = append(rc.AllExcludes, line)
+		}
+	}
+
+	rc.DefaultRegion = cfg.DefaultRegion
+	return nil
+}
+
+// findRouterConfigFile returns the explicitly configured path or the first default config file found in sourceDir
+func findRouterConfigFile(configPath, sourceDir string) (string, error) {
+	if configPath != "" {
+		return configPath, nil
+	}
+	for _, name := range defaultRouterConfigFiles {
+		pth := filepath.Join(sourceDir, name)
+		if exists, err := pathutil.IsPathExists(pth); err != nil {
+			return "", err
+		} else if exists {
+			return pth, nil
+		}
+	}
+	return "", nil
+}
+
+// loadRouterConfig builds the router config from the step inputs, overlaid with the config file if there is one.
+// Settings missing from the file keep their input or default value.
+func loadRouterConfig(cfg Config, sourceDir string) (RouterConfig, error) {
+	rc := defaultRouterConfig()
+	if err := rc.applyStepInputs(cfg); err != nil {
+		return RouterConfig{}, err
+	}
+
+	pth, err := findRouterConfigFile(cfg.RouterConfigPath, sourceDir)
+	if err != nil {
+		return RouterConfig{}, fmt.Errorf("failed to look up router config, error: %s", err)
+	}
+	if pth != "" {
+		log.Infof("Loading router config from %s", pth)
+		content, err := ioutil.ReadFile(pth)
+		if err != nil {
+			return RouterConfig{}, fmt.Errorf("failed to read router config, error: %s", err)
+		}
+		if err := yaml.UnmarshalStrict(content, &rc); err != nil {
+			return RouterConfig{}, fmt.Errorf("failed to parse router config %s, error: %s", pth, err)
+		}
+	} else {
+		log.Debugf("No router config file found, using step inputs")
+	}
+
+	if err := rc.validate(); err != nil {
+		return RouterConfig{}, err
+	}
+	return rc, nil
+}
+
+// validate checks the config against the schema, reporting every problem at once
+func (rc RouterConfig) validate() error {
+	var problems []string
+	addProblem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	if rc.Version != routerConfigVersion {
+		addProblem("unsupported version %d, expected %d", rc.Version, routerConfigVersion)
+	}
+
+	if len(rc.Regions) == 0 {
+		addProblem("no regions defined")
+	}
+	codes := make(map[string]bool)
+	regionTokens := make(map[string]string)
+	for i, region := range rc.Regions {
+		if region.Code == "" || region.Name == "" {
+			addProblem("regions[%d]: code and name are required", i)
+			continue
+		}
+		code := strings.ToUpper(region.Code)
+		if codes[code] {
+			addProblem("regions[%d]: duplicate code %s", i, region.Code)
+		}
+		codes[code] = true
+
+		for _, token := range []string{region.Code, region.Alias} {
+			if token == "" {
+				continue
+			}
+			token = strings.ToUpper(token)
+			if rc.vendor(token) != "" || strings.EqualFold(token, rc.Tag.AllMarker) || strings.EqualFold(token, rc.Tag.APKMarker) {
+				addProblem("regions[%d]: %s is ambiguous with a tag keyword", i, token)
+			}
+			if other, ok := regionTokens[token]; ok && other != code {
+				addProblem("regions[%d]: %s is ambiguous with region %s", i, token, other)
+			}
+			regionTokens[token] = code
+		}
+	}
+	if rc.Tag.ExcludePrefix == "" {
+		addProblem("tag.exclude_prefix is required")
+	} else {
+		prefix := strings.ToUpper(rc.Tag.ExcludePrefix)
+		for token, code := range regionTokens {
+			if other, ok := regionTokens[strings.TrimPrefix(token, prefix)]; ok && strings.HasPrefix(token, prefix) {
+				addProblem("regions: %s of region %s is ambiguous with excluding region %s", token, code, other)
+			}
+		}
+	}
+	if rc.DefaultRegion == "" {
+		addProblem("default_region is required")
+	} else if !codes[strings.ToUpper(rc.DefaultRegion)] {
+		addProblem("default_region: unknown region %s", rc.DefaultRegion)
+	}
+	for _, code := range rc.AllExcludes {
+		if !codes[strings.ToUpper(code)] {
+			addProblem("all_excludes: unknown region %s", code)
+		}
+	}
+
+	vendors := make(map[string]bool)
+	for _, vendor := range rc.Vendors.Supported {
+		vendors[vendor] = true
+	}
+	if len(rc.Vendors.Default) == 0 {
+		addProblem("vendors.default is required")
+	}
+	for _, vendor := range rc.Vendors.Default {
+		if !vendors[vendor] {
+			addProblem("vendors.default: %s is not a supported vendor", vendor)
+		}
+	}
+	for _, vendor := range rc.Vendors.Bundle {
+		if !vendors[vendor] {
+			addProblem("vendors.bundle: %s is not a supported vendor", vendor)
+		}
+	}
+
+	for i, region := range rc.Regions {
+		for _, vendor := range region.Vendors {
+			if !vendors[vendor] {
+				addProblem("regions[%d].vendors: %s is not a supported vendor", i, vendor)
+			}
+		}
+		for _, name := range region.BuildTypes {
+			if _, ok := rc.BuildTypes[name]; !ok {
+				addProblem("regions[%d].build_types: unknown build type %s", i, name)
+			}
+		}
+	}
+
+	for _, buildType := range []BuildType{Debug, Qa, Release} {
+		if _, ok := rc.BuildTypes[buildType.Name()]; !ok {
+			addProblem("build_types: %s is required", buildType)
+		}
+	}
+	tagPatterns := []struct {
+		name    string
+		pattern string
+		exp     *regexp.Regexp
+	}{
+		{name: "tag.rc_pattern", pattern: rc.Tag.RCPattern},
+		{name: "tag.prerelease_pattern", pattern: rc.Tag.PrereleasePattern},
+	}
+	for i, tagPattern := range tagPatterns {
+		if _, err := regexp.Compile(tagPattern.pattern); err != nil {
+			addProblem("%s: %s", tagPattern.name, err)
+			continue
+		}
+		tagPatterns[i].exp = fullMatch(tagPattern.pattern)
+	}
+
+	ids := make(map[int]string)
+	for _, name := range rc.buildTypeNames() {
+		buildType := rc.BuildTypes[name]
+		if other, ok := ids[buildType.ID]; ok {
+			addProblem("build_types.%s: id %d already used by %s", name, buildType.ID, other)
+		}
+		ids[buildType.ID] = name
+		if buildType.Marker == "" {
+			continue
+		}
+		if _, err := regexp.Compile(buildType.Marker); err != nil {
+			addProblem("build_types.%s.marker: %s", name, err)
+		} else if _, ok := rc.regionForToken(buildType.Marker); ok || rc.vendor(buildType.Marker) != "" {
+			addProblem("build_types.%s.marker: %s is ambiguous with a region or vendor", name, buildType.Marker)
+		}
+		for _, tagPattern := range tagPatterns {
+			if tagPattern.exp != nil && tagPattern.exp.MatchString(buildType.Marker) {
+				addProblem("build_types.%s.marker: %s is ambiguous with %s", name, buildType.Marker, tagPattern.name)
+			}
+		}
+	}
+
+	for i, rule := range rc.Branches {
+		if rule.Pattern == "" {
+			addProblem("branches[%d].pattern: required", i)
+		}
+		if _, ok := rc.BuildTypes[rule.BuildType]; rule.BuildType != "" && !ok {
+			addProblem("branches[%d].build_type: unknown build type %s", i, rule.BuildType)
+		}
+		if rule.AllRegions && len(rule.Regions) > 0 {
+			addProblem("branches[%d]: regions conflict with all_regions", i)
+		}
+		for _, code := range rule.Regions {
+			if _, ok := rc.region(code); !ok {
+				addProblem("branches[%d].regions: unknown region %s", i, code)
+			}
+		}
+		for _, vendor := range rule.Vendors {
+			if !vendors[vendor] {
+				addProblem("branches[%d].vendors: %s is not a supported vendor", i, vendor)
+			}
+		}
+	}
+
+	for i, rule := range rc.Workflows.Rules {
+		if rule.Workflow == "" {
+			addProblem("workflows.rules[%d].workflow: required", i)
+		}
+		if _, ok := rc.region(rule.Region); rule.Region != "" && !ok {
+			addProblem("workflows.rules[%d].region: unknown region %s", i, rule.Region)
+		}
+		if rule.Vendor != "" && !vendors[rule.Vendor] {
+			addProblem("workflows.rules[%d].vendor: %s is not a supported vendor", i, rule.Vendor)
+		}
+		if _, ok := rc.BuildTypes[rule.BuildType]; rule.BuildType != "" && !ok {
+			addProblem("workflows.rules[%d].build_type: unknown build type %s", i, rule.BuildType)
+		}
+	}
+
+	for vendor := range rc.Naming.VendorPackageSuffixes {
+		if !vendors[vendor] {
+			addProblem("naming.vendor_package_suffixes: %s is not a supported vendor", vendor)
+		}
+	}
+	for vendor := range rc.Naming.ServicesConfigPaths {
+		if !vendors[vendor] {
+			addProblem("naming.services_config_paths: %s is not a supported vendor", vendor)
+		}
+	}
+
+	if rc.Naming.PackageBase == "" {
+		addProblem("naming.package_base is required")
+	}
+	if len(rc.Naming.BuildTaskTemplates) == 0 || len(rc.Naming.TestTaskTemplates) == 0 {
+		addProblem("naming.build_task_templates and naming.test_task_templates are required")
+	}
+	if len(problems) == 0 {
+		// try every template with every combination, so a broken one fails before anything is built
+	regions:
+		for _, region := range rc.Regions {
+			for _, vendor := range rc.Vendors.Supported {
+				for _, name := range rc.buildTypeNames() {
+					buildType := BuildType(name)
+					if _, err := generatePackageName(&rc, region, region.Code, vendor, &buildType); err != nil {
+						addProblem("%s", err)
+						continue regions
+					}
+					taskData := gradleTaskData{Command: "assemble", Region: region.Name, Code: region.Code, A2: region.Code, Vendor: vendor, BuildType: rc.buildType(buildType).GradleName}
+					for _, templates := range [][]string{rc.Naming.BuildTaskTemplates, rc.Naming.TestTaskTemplates} {
+						if _, err := generateGradleTasks(templates, taskData); err != nil {
+							addProblem("%s", err)
+							continue regions
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid router config:\n- %s", strings.Join(problems, "\n- "))
+	}
+	return nil
+}
+
+// region returns the region with the given code, ignoring case
+func (rc RouterConfig) region(code string) (RegionConfig, bool) {
+	for _, region := range rc.Regions {
+		if strings.EqualFold(region.Code, code) {
+			return region, true
+		}
+	}
+	return RegionConfig{}, false
+}
+
+// regionForToken returns the region whose code or alias matches token, ignoring case
+func (rc RouterConfig) regionForToken(token string) (RegionConfig, bool) {
+	for _, region := range rc.Regions {
+		if strings.EqualFold(region.Code, token) || (region.Alias != "" && strings.EqualFold(region.Alias, token)) {
+			return region, true
+		}
+	}
+	return RegionConfig{}, false
+}
+
+// isExcludedFromAll ...
+func (rc RouterConfig) isExcludedFromAll(code string) bool {
+	for _, exclude := range rc.AllExcludes {
+		if strings.EqualFold(exclude, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// vendor returns the supported vendor matching token, ignoring case, or an empty string
+func (rc RouterConfig) vendor(token string) string {
+	for _, v := range rc.Vendors.Supported {
+		if strings.EqualFold(v, token) {
+			return v
+		}
+	}
+	return ""
+}
+
+// branchRule returns the first rule matching the branch
+func (rc RouterConfig) branchRule(branch string) (BranchRule, bool) {
+	for _, rule := range rc.Branches {
+		if rule.matches(branch) {
+			return rule, true
+		}
+	}
+	return BranchRule{}, false
+}
+
+// workflow returns the workflow a build runs, empty for the triggered workflow
+func (rc RouterConfig) workflow(region, vendor string, buildType BuildType) string {
+	for _, rule := range rc.Workflows.Rules {
+		if rule.matches(region, vendor, buildType) {
+			return rule.Workflow
+		}
+	}
+	return rc.Workflows.Default
+}
+
+// buildType returns the registered config of the build type, with its defaults applied
+func (rc RouterConfig) buildType(buildType BuildType) BuildTypeConfig {
+	cfg := rc.BuildTypes[buildType.Name()]
+	if cfg.GradleName == "" {
+		cfg.GradleName = buildType.Name()
+	}
+	return cfg
+}
+
+// buildTypeNames returns the registered build types in a stable order
+func (rc RouterConfig) buildTypeNames() []string {
+	var names []string
+	for name := range rc.BuildTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isBundleVendor ...
+func (rc RouterConfig) isBundleVendor(vendor string) bool {
+	for _, v := range rc.Vendors.Bundle {
+		if v == vendor {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_loadRouterConfig(t *testing.T) {
+	inputs := Config{
+		DefaultRegion:         "SG",
+		SupportedRegions:      "SG=singapore\nAU=australia\nJP=japan",
+		SupportedRegionsAlias: "AU=au",
+		AllTagExcludes:        "JP",
+		BasePackageRegions:    "SG",
+	}
+
+	tests := []struct {
+		name       string
+		inputs     Config
+		fileName   string
+		content    string
+		wantErr    bool
+		wantConfig func(rc *RouterConfig)
+	}{
+		{
+			name:   "step inputs only",
+			inputs: inputs,
+			wantConfig: func(rc *RouterConfig) {
+				rc.DefaultRegion = "SG"
+				rc.Regions = []RegionConfig{
+					{Code: "SG", Name: "singapore", BasePackage: true},
+					{Code: "AU", Name: "australia", Alias: "au"},
+					{Code: "JP", Name: "japan"},
+				}
+				rc.AllExcludes = []string{"JP"}
+			},
+		},
+		{
+			name:     "yaml file overrides inputs",
+			inputs:   inputs,
+			fileName: "router.yml",
+			content: `version: 1
+default_region: ID
+regions:
+- code: ID
+  name: indonesia
+all_excludes: []
+naming:
+  package_base: com.example.app
+`,
+			wantConfig: func(rc *RouterConfig) {
+				rc.DefaultRegion = "ID"
+				rc.Regions = []RegionConfig{{Code: "ID", Name: "indonesia"}}
+				rc.AllExcludes = []string{}
+				rc.Naming.PackageBase = "com.example.app"
+			},
+		},
+		{
+			name:     "json file",
+			inputs:   inputs,
+			fileName: "router.json",
+			content:  `{"version": 1, "vendors": {"default": "HMS"}}`,
+			wantConfig: func(rc *RouterConfig) {
+				rc.DefaultRegion = "SG"
+				rc.Regions = []RegionConfig{
+					{Code: "SG", Name: "singapore", BasePackage: true},
+					{Code: "AU", Name: "australia", Alias: "au"},
+					{Code: "JP", Name: "japan"},
+				}
+				rc.AllExcludes = []string{"JP"}
+				rc.Vendors.Default = vendorList{"HMS"}
+			},
+		},
+		{
+			name:     "vendor list",
+			inputs:   inputs,
+			fileName: "router.yml",
+			content:  "version: 1\nvendors:\n  default: [GMS, HMS]\n",
+			wantConfig: func(rc *RouterConfig) {
+				rc.DefaultRegion = "SG"
+				rc.Regions = []RegionConfig{
+					{Code: "SG", Name: "singapore", BasePackage: true},
+					{Code: "AU", Name: "australia", Alias: "au"},
+					{Code: "JP", Name: "japan"},
+				}
+				rc.AllExcludes = []string{"JP"}
+				rc.Vendors.Default = vendorList{"GMS", "HMS"}
+			},
+		},
+		{
+			name:     "registered build type",
+			inputs:   inputs,
+			fileName: "router.yml",
+			content:  "version: 1\nbuild_types:\n  staging:\n    id: 3\n    marker: STG\n    package_suffix: .stg\n",
+			wantConfig: func(rc *RouterConfig) {
+				rc.DefaultRegion = "SG"
+				rc.Regions = []RegionConfig{
+					{Code: "SG", Name: "singapore", BasePackage: true},
+					{Code: "AU", Name: "australia", Alias: "au"},
+					{Code: "JP", Name: "japan"},
+				}
+				rc.AllExcludes = []string{"JP"}
+				rc.BuildTypes["staging"] = BuildTypeConfig{ID: 3, Marker: "STG", PackageSuffix: ".stg"}
+			},
+		},
+		{
+			name:     "built-in build type partially overridden",
+			inputs:   inputs,
+			fileName: "router.yml",
+			content:  "version: 1\nbuild_types:\n  release:\n    id: 2\n    browserstack_suffix: PROD-AAB\n",
+			wantConfig: func(rc *RouterConfig) {
+				rc.DefaultRegion = "SG"
+				rc.Regions = []RegionConfig{
+					{Code: "SG", Name: "singapore", BasePackage: true},
+					{Code: "AU", Name: "australia", Alias: "au"},
+					{Code: "JP", Name: "japan"},
+				}
+				rc.AllExcludes = []string{"JP"}
+				rc.BuildTypes["release"] = BuildTypeConfig{ID: 2, Release: true, BrowserstackSuffix: "PROD-AAB"}
+			},
+		},
+		{
+			name:     "unknown build type field",
+			inputs:   inputs,
+			fileName: "router.yml",
+			content:  "version: 1\nbuild_types:\n  release:\n    bundle: true\n",
+			wantErr:  true,
+		},
+		{
+			name:     "duplicate build type id",
+			inputs:   inputs,
+			fileName: "router.yml",
+			content:  "version: 1\nbuild_types:\n  staging:\n    id: 1\n",
+			wantErr:  true,
+		},
+		{
+			name:     "build type marker ambiguous with region",
+			inputs:   inputs,
+			fileName: "router.yml",
+			content:  "version: 1\nbuild_types:\n  staging:\n    id: 3\n    marker: AU\n",
+			wantErr:  true,
+		},
+		{
+			name:     "build type marker ambiguous with prerelease",
+			inputs:   inputs,
+			fileName: "router.yml",
+			content:  "version: 1\nbuild_types:\n  beta:\n    id: 3\n    marker: BETA\n",
+			wantErr:  true,
+		},
+		{
+			name:     "branch rule with unknown region",
+			inputs:   inputs,
+			fileName: "router.yml",
+			content:  "version: 1\nbranches:\n- pattern: release/*\n  build_type: qa\n  regions: [XX]\n",
+			wantErr:  true,
+		},
+		{
+			name:     "workflow rule with unsupported vendor",
+			inputs:   inputs,
+			fileName: "router.yml",
+			content:  "version: 1\nworkflows:\n  rules:\n  - vendor: IOS\n    workflow: ios-release\n",
+			wantErr:  true,
+		},
+		{
+			name:     "unknown field",
+			inputs:   inputs,
+			fileName: "router.yml",
+			content:  "version: 1\nregion: AU\n",
+			wantErr:  true,
+		},
+		{
+			name:     "unsupported version",
+			inputs:   inputs,
+			fileName: "router.yml",
+			content:  "version: 2\n",
+			wantErr:  true,
+		},
+		{
+			name:    "unknown default region",
+			inputs:  Config{DefaultRegion: "XX", SupportedRegions: "SG=singapore"},
+			wantErr: true,
+		},
+		{
+			name:    "region ambiguous with exclusion",
+			inputs:  Config{DefaultRegion: "SG", SupportedRegions: "SG=singapore\nNOSG=nosingapore"},
+			wantErr: true,
+		},
+		{
+			name:   "base package region",
+			inputs: Config{DefaultRegion: "SG", SupportedRegions: "SG=singapore\nAU=australia", BasePackageRegions: "AU"},
+			wantConfig: func(rc *RouterConfig) {
+				rc.DefaultRegion = "SG"
+				rc.Regions = []RegionConfig{
+					{Code: "SG", Name: "singapore"},
+					{Code: "AU", Name: "australia", BasePackage: true},
+				}
+			},
+		},
+		{
+			name:    "unknown base package region",
+			inputs:  Config{DefaultRegion: "SG", SupportedRegions: "SG=singapore", BasePackageRegions: "SG\nXX"},
+			wantErr: true,
+		},
+		{
+			name:     "regions only in the config file",
+			inputs:   Config{BasePackageRegions: "SG"},
+			fileName: "router.yml",
+			content:  "version: 1\ndefault_region: SG\nregions:\n- code: SG\n  name: singapore\n  base_package: true\n- code: AU\n  name: australia\n",
+			wantConfig: func(rc *RouterConfig) {
+				rc.DefaultRegion = "SG"
+				rc.Regions = []RegionConfig{
+					{Code: "SG", Name: "singapore", BasePackage: true},
+					{Code: "AU", Name: "australia"},
+				}
+			},
+		},
+		{
+			name:    "malformed supported regions",
+			inputs:  Config{DefaultRegion: "SG", SupportedRegions: "SG"},
+			wantErr: true,
+		},
+		{
+			name:    "no regions",
+			inputs:  Config{DefaultRegion: "SG"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "router-config")
+			require.NoError(t, err)
+			defer func() {
+				require.NoError(t, os.RemoveAll(dir))
+			}()
+
+			if tt.fileName != "" {
+				require.NoError(t, ioutil.WriteFile(filepath.Join(dir, tt.fileName), []byte(tt.content), 0600))
+			}
+
+			got, err := loadRouterConfig(tt.inputs, dir)
+			if tt.wantErr {
+				require.Error(t, err, "loadRouterConfig() expected to return error")
+				return
+			}
+			require.NoError(t, err, "loadRouterConfig() err")
+
+			want := defaultRouterConfig()
+			tt.wantConfig(&want)
+			require.Equal(t, want, got, "loadRouterConfig()")
+		})
+	}
+}
@@ -5,7 +5,6 @@ go 1.16
 require (
 	github.com/bitrise-io/go-steputils v0.0.0-20200227150459-94490ca44ddb
 	github.com/bitrise-io/go-utils v0.0.0-20200224122728-e212188d99b4
-	github.com/bitrise-steplib/bitrise-step-build-router-start v0.0.0-20200805164640-63a29bf46ca6
 	github.com/fatih/color v1.9.0 // indirect
 	github.com/hashicorp/go-cleanhttp v0.5.1 // indirect
 	github.com/hashicorp/go-hclog v0.13.0 // indirect
@@ -13,5 +12,5 @@ require (
 	github.com/mattn/go-colorable v0.1.6 // indirect
 	github.com/stretchr/testify v1.5.1
 	golang.org/x/sys v0.0.0-20200513112337-417ce2331b5c // indirect
-	gopkg.in/yaml.v2 v2.3.0 // indirect
+	gopkg.in/yaml.v2 v2.3.0
 )
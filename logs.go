@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// tailLines returns the last n lines of a log
+func tailLines(buildLog string, n int) []string {
+	lines := strings.Split(strings.TrimRight(buildLog, "\n"), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return nil
+	}
+	if n < len(lines) {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// logFileName names the saved log of a forked build, e.g. AU-GMS-qa-build-1234.log
+func logFileName(child *childBuild) string {
+	return joinIgnoreEmpty([]string{child.A2Code, child.Vendor, child.BuildType, fmt.Sprintf("build-%d.log", child.BuildNumber)}, "-")
+}
+
+// reportFailedLogs prints the last lines of the logs of the failed builds, prefixed with their region,
+// and saves the full logs to the deploy dir. Logs which cannot be fetched are only warned about.
+func reportFailedLogs(failed childBuilds, fetch func(buildSlug string) (string, error), lines int, deployDir string) []string {
+	var paths []string
+	for _, child := range failed {
+		buildLog, err := fetch(child.BuildSlug)
+		if err != nil {
+			log.Warnf("Failed to fetch log of %s %s (#%d), error: %s", child.Region, child.Vendor, child.BuildNumber, err)
+			continue
+		}
+
+		if lines > 0 {
+			log.Infof("Last %d lines of %s %s (#%d):", lines, child.Region, child.Vendor, child.BuildNumber)
+			for _, line := range tailLines(buildLog, lines) {
+				log.Printf("[%s] %s", child.A2Code, line)
+			}
+		}
+
+		if deployDir == "" {
+			continue
+		}
+		path := filepath.Join(deployDir, logFileName(child))
+		if err := ioutil.WriteFile(path, []byte(buildLog), 0644); err != nil {
+			log.Warnf("Failed to save log of %s %s (#%d), error: %s", child.Region, child.Vendor, child.BuildNumber, err)
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
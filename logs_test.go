@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vielasis/bitrise-step-build-router-start/bitrise"
+)
+
+func Test_tailLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		buildLog string
+		n        int
+		want     []string
+	}{
+		{name: "empty", buildLog: "", n: 3, want: nil},
+		{name: "shorter than n", buildLog: "a\nb\n", n: 3, want: []string{"a", "b"}},
+		{name: "last lines", buildLog: "a\nb\nc\nd\n", n: 2, want: []string{"c", "d"}},
+		{name: "without trailing newline", buildLog: "a\nb\nc", n: 1, want: []string{"c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tailLines(tt.buildLog, tt.n), "tailLines()")
+		})
+	}
+}
+
+func Test_reportFailedLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "router-logs")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+
+	var failed childBuilds
+	failed.add(BuildParams{BuildRegion: "Australia", Alpha2Code: "AU", VendorService: "GMS", BuildTypeName: "qa"}, bitrise.StartResponse{BuildSlug: "au", BuildNumber: 12})
+	failed.add(BuildParams{BuildRegion: "Japan", Alpha2Code: "JP", VendorService: "GMS", BuildTypeName: "qa"}, bitrise.StartResponse{BuildSlug: "jp", BuildNumber: 13})
+
+	paths := reportFailedLogs(failed, func(buildSlug string) (string, error) {
+		if buildSlug == "jp" {
+			return "", fmt.Errorf("statuscode: 404")
+		}
+		return "gradle failed\n", nil
+	}, 10, dir)
+
+	require.Equal(t, []string{filepath.Join(dir, "AU-GMS-qa-build-12.log")}, paths, "reportFailedLogs()")
+	b, err := ioutil.ReadFile(paths[0])
+	require.NoError(t, err)
+	require.Equal(t, "gradle failed\n", string(b), "reportFailedLogs() saved log")
+}
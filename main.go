@@ -1,32 +1,71 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"reflect"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/bitrise-io/go-steputils/stepconf"
 	"github.com/bitrise-io/go-steputils/tools"
 	"github.com/bitrise-io/go-utils/log"
-	"github.com/bitrise-steplib/bitrise-step-build-router-start/bitrise"
+	"github.com/vielasis/bitrise-step-build-router-start/bitrise"
 )
 
 const envBuildSlugs = "ROUTER_STARTED_BUILD_SLUGS"
 
+// sensitiveKeyExp matches env keys likely holding secrets
+var sensitiveKeyExp = regexp.MustCompile(`(?i)(token|secret|passw|pwd|credential|private|api_?key|signing|keystore)`)
+
 // Config ...
 type Config struct {
 	ParentBuild           string          `env:"SOURCE_BITRISE_BUILD_NUMBER"`
-	AppSlug               string          `env:"BITRISE_APP_SLUG,required"`
-	BuildSlug             string          `env:"BITRISE_BUILD_SLUG,required"`
-	BuildNumber           string          `env:"BITRISE_BUILD_NUMBER,required"`
-	AccessToken           stepconf.Secret `env:"access_token,required"`
-	DefaultRegion         string          `env:"default_region,required"`
-	SupportedRegions      string          `env:"supported_regions,required"`
+	AppSlug               string          `env:"BITRISE_APP_SLUG"`
+	BuildSlug             string          `env:"BITRISE_BUILD_SLUG"`
+	BuildNumber           string          `env:"BITRISE_BUILD_NUMBER"`
+	AccessToken           stepconf.Secret `env:"access_token"`
+	DefaultRegion         string          `env:"default_region"`
+	SupportedRegions      string          `env:"supported_regions"`
 	SupportedRegionsAlias string          `env:"supported_regions_alias"`
 	AllTagExcludes        string          `env:"all_tag_excludes"`
-	IsVerboseLog          bool            `env:"verbose,required"`
+	BasePackageRegions    string          `env:"base_package_regions"`
+	RouterConfigPath      string          `env:"router_config"`
+	IsVerboseLog          bool            `env:"verbose"`
+	DryRun                bool            `env:"dry_run"`
+	WaitForBuilds         bool            `env:"wait_for_builds"`
+	AbortPolicy           string          `env:"abort_policy"`
+	ArtifactPatterns      string          `env:"artifact_patterns"`
+	DeployDir             string          `env:"BITRISE_DEPLOY_DIR"`
+	MaxParallelStarts     int             `env:"max_parallel_starts"`
+	StartFailurePolicy    string          `env:"start_failure_policy"`
+	RebuildPolicy         string          `env:"rebuild_policy"`
+	EnvironmentKeyList    string          `env:"environment_key_list"`
+	AllowedSensitiveEnvs  string          `env:"allowed_sensitive_envs"`
+	FailedLogLines        int             `env:"failed_log_lines"`
+	WaitTimeout           string          `env:"wait_timeout"`
+	SlackWebhookURL       stepconf.Secret `env:"slack_webhook_url"`
+	SlackChannel          string          `env:"slack_channel"`
+}
+
+// validateForFork checks the inputs which are only needed to fork builds, i.e. not in dry run mode
+func (cfg Config) validateForFork() error {
+	for name, value := range map[string]string{
+		"BITRISE_APP_SLUG":     cfg.AppSlug,
+		"BITRISE_BUILD_SLUG":   cfg.BuildSlug,
+		"BITRISE_BUILD_NUMBER": cfg.BuildNumber,
+		"access_token":         string(cfg.AccessToken),
+	} {
+		if value == "" {
+			return fmt.Errorf("%s: required variable is not present", name)
+		}
+	}
+	return nil
 }
 
 func failf(s string, a ...interface{}) {
@@ -35,98 +74,358 @@ func failf(s string, a ...interface{}) {
 }
 
 func main() {
+	dryRun := flag.Bool("dry-run", false, "print the build plan without exporting envs or starting builds")
+	tag := flag.String("tag", "", "route this tag instead of $BITRISE_GIT_TAG")
+	branch := flag.String("branch", "", "route this branch instead of $BITRISE_GIT_BRANCH")
+	flag.Parse()
+
 	var cfg Config
 	if err := stepconf.Parse(&cfg); err != nil {
 		failf("Issue with an input: %s", err)
 	}
+	cfg.DryRun = cfg.DryRun || *dryRun
 
 	stepconf.Print(cfg)
 	fmt.Println()
 
+	log.SetEnableDebugLog(cfg.IsVerboseLog)
+
+	policy, err := parseAbortPolicy(cfg.AbortPolicy)
+	if err != nil {
+		failf("Issue with an input: %s", err)
+	}
+	if err := validateStartFailurePolicy(cfg.StartFailurePolicy); err != nil {
+		failf("Issue with an input: %s", err)
+	}
+	if err := validateRebuildPolicy(cfg.RebuildPolicy); err != nil {
+		failf("Issue with an input: %s", err)
+	}
+	waitTimeout, err := parseWaitTimeout(cfg.WaitTimeout)
+	if err != nil {
+		failf("Issue with an input: %s", err)
+	}
+	if err := validateSlackWebhookURL(string(cfg.SlackWebhookURL)); err != nil {
+		failf("Issue with an input: %s", err)
+	}
+	environments := createEnvs(cfg.EnvironmentKeyList)
+	if err := checkSensitiveEnvs(environments, cfg.AllowedSensitiveEnvs, string(cfg.AccessToken), string(cfg.SlackWebhookURL)); err != nil {
+		failf("Issue with an input: %s", err)
+	}
+	if err := validateArtifactPatterns(parseArtifactPatterns(cfg.ArtifactPatterns)); err != nil {
+		failf("Issue with an input: %s", err)
+	}
+
+	routerCfg, err := loadRouterConfig(cfg, os.Getenv("BITRISE_SOURCE_DIR"))
+	if err != nil {
+		failf("Failed to load router config, error: %s", err)
+	}
+
+	ref := gitRefFromEnv()
+	if *tag != "" || *branch != "" {
+		ref.Tag, ref.Branch = *tag, *branch
+	}
+
 	if cfg.ParentBuild == "" {
 		log.Infof("I am the master. I will fork more if necessary")
 	} else {
+		// forked builds get their tag overridden, make sure it is still one the router understands
+		if ref.Tag != "" {
+			spec, err := parseTagSpec(&routerCfg, ref.Tag, true)
+			if err != nil {
+				failf("Child build of %s has an invalid tag, error: %s", cfg.ParentBuild, err)
+			}
+			log.Debugf("Parsed tag: %+v", spec)
+		}
 		log.Infof("Bypassing script, child build of %s", cfg.ParentBuild)
 		return
 	}
 
-	log.SetEnableDebugLog(cfg.IsVerboseLog)
+	var trace routeTrace
+	buildParams, err := generateBuildParams(&routerCfg, ref, &trace)
+	if err != nil {
+		failf("Failed to generate build params, error: %s", err)
+	}
+	spec, err := parseTagSpec(&routerCfg, ref.token(), ref.Tag != "")
+	if err != nil {
+		failf("Failed to parse %s, error: %s", ref.token(), err)
+	}
+
+	// forks run the triggered workflow unless the router config routes them elsewhere
+	workflow := os.Getenv("BITRISE_TRIGGERED_WORKFLOW_ID")
+	buildParams, err = routeWorkflows(buildParams, workflow)
+	if err != nil {
+		failf("Failed to route workflows, error: %s", err)
+	}
+
+	plan := newBuildPlan(ref, trace, buildParams)
+	if cfg.DryRun {
+		log.Infof("Dry run, nothing will be exported or started. Build plan:")
+		if err := plan.printTable(os.Stdout); err != nil {
+			failf("Failed to print build plan, error: %s", err)
+		}
+		fmt.Println()
+		if err := plan.printJSON(os.Stdout); err != nil {
+			failf("Failed to print build plan, error: %s", err)
+		}
+		return
+	}
+
+	if err := cfg.validateForFork(); err != nil {
+		failf("Issue with an input: %s", err)
+	}
 
 	app := bitrise.NewAppWithDefaultURL(cfg.AppSlug, string(cfg.AccessToken))
 
 	build, err := app.GetBuild(cfg.BuildSlug)
-	if err != nil {
+	if bitrise.IsStatus(err, http.StatusUnauthorized) || bitrise.IsStatus(err, http.StatusForbidden) {
+		failf("Access token has no access to app %s, error: %s", cfg.AppSlug, err)
+	} else if err != nil {
 		failf("failed to get build, error: %s", err)
 	}
 
+	if len(buildParams) > 1 {
+		workflows, err := app.ListWorkflows()
+		if err != nil {
+			failf("Failed to list workflows, error: %s", err)
+		}
+		if unknown := unknownWorkflows(buildParams, workflows); len(unknown) > 0 {
+			failf("Builds are routed to workflows missing from the app: %s", strings.Join(unknown, ", "))
+		}
+	}
+
 	log.Infof("Starting builds:")
 
-	// parse supported regions
-	supportedRegions := make(map[string]string)
-	for _, line := range strings.Split(cfg.SupportedRegions, "\n") {
-		pair := strings.Split(line, "=")
-		key := pair[0]
-		value := pair[1]
-		supportedRegions[key] = value
+	// the first build stays in this build, the rest is forked
+	buildParam := buildParams[0]
+	log.Infof(fmt.Sprintf("BuildParam: %v", buildParam))
+	writeBuildParamsToEnvs(&buildParam, nil) // write to envman directly!
+	// rewrite tag if necessary
+	if buildParam.NewTag != "" {
+		oldTag := os.Getenv("BITRISE_GIT_TAG")
+		log.Infof(fmt.Sprintf("Overriding TAG: %s -> %s", oldTag, buildParam.NewTag))
+		if err := tools.ExportEnvironmentWithEnvman("BITRISE_GIT_TAG", buildParam.NewTag); err != nil {
+			failf("Unable to overwrite BITRISE_GIT_TAG")
+		}
 	}
-	// parse all tag excludes
-	allTagExcludes := make(map[string]bool)
-	for _, line := range strings.Split(cfg.AllTagExcludes, "\n") {
-		if len(line) != 0 {
-			allTagExcludes[line] = true
+	if buildParam.NewCommitHash != "" {
+		if err := tools.ExportEnvironmentWithEnvman("BITRISE_GIT_COMMIT", buildParam.NewCommitHash); err != nil {
+			failf("Unable to overwrite BITRISE_GIT_COMMIT")
 		}
 	}
 
-	supportedRegionAlias := make(map[string]string)
-	for _, line := range strings.Split(cfg.SupportedRegionsAlias, "\n") {
-		pair := strings.Split(line, "=")
-		key := pair[0]
-		value := pair[1]
-		supportedRegionAlias[key] = value
+	// a rebuilt parent finds the builds its earlier run forked for the same commit
+	toStart := buildParams[1:]
+	var kept startReport
+	rebuilt := false
+	if len(toStart) > 0 && build.CommitHash != "" {
+		earlierRuns, err := app.ListBuilds(earlierRunLookup(build, time.Now()))
+		if err != nil {
+			failf("Failed to list earlier runs, error: %s", err)
+		}
+		rebuilt = isRebuild(earlierRuns, build)
+	}
+	if rebuilt {
+		log.Infof("Rebuild of an earlier run, looking up the builds it forked")
+		builds, err := app.ListBuilds(rebuildLookup(build, toStart, time.Now()))
+		if err != nil {
+			failf("Failed to list earlier builds, error: %s", err)
+		}
+		var replaced []bitrise.Build
+		toStart, kept, replaced = planRebuild(toStart, build, findEarlierChildren(builds, build), cfg.RebuildPolicy)
+		for _, result := range kept {
+			log.Printf("- %s %s already forked in build #%d, %s", result.Region, result.Vendor, result.BuildNumber, result.Status)
+		}
+		for _, earlier := range replaced {
+			log.Warnf("Aborting build #%d, replaced by this build", earlier.BuildNumber)
+			if err := app.AbortBuild(earlier.Slug, fmt.Sprintf("replaced by router in build #%s", cfg.BuildNumber)); err != nil {
+				log.Warnf("Failed to abort build %s, error: %s", earlier.Slug, err)
+			}
+		}
 	}
 
-	var buildSlugs []string
-	var environments []bitrise.Environment
-
-	for i, buildParam := range generateBuildParams(supportedRegions, allTagExcludes, supportedRegionAlias, cfg.DefaultRegion) {
+	report := startBuilds(toStart, cfg.MaxParallelStarts, cfg.StartFailurePolicy, func(buildParam BuildParams) (bitrise.StartResponse, error) {
 		log.Infof(fmt.Sprintf("BuildParam: %v", buildParam))
-		if i == 0 {
-			writeBuildParamsToEnvs(&buildParam, nil) // write to envman directly!
-			// rewrite tag if necessary
-			if buildParam.NewTag != "" {
-				oldTag := os.Getenv("BITRISE_GIT_TAG")
-				log.Infof(fmt.Sprintf("Overriding TAG: %s -> %s", oldTag, buildParam.NewTag))
-				if err := tools.ExportEnvironmentWithEnvman("BITRISE_GIT_TAG", buildParam.NewTag); err != nil {
-					failf("Unable to overwrite BITRISE_GIT_TAG")
-				}
-			}
-			if buildParam.NewCommitHash != "" {
-				if err := tools.ExportEnvironmentWithEnvman("BITRISE_GIT_COMMIT", buildParam.NewCommitHash); err != nil {
-					failf("Unable to overwrite BITRISE_GIT_COMMIT")
-				}
-			}
-		} else {
-			newEnvs := writeBuildParamsToEnvs(&buildParam, &environments)
-			// always fork the triggered workflow
-			workflow := os.Getenv("BITRISE_TRIGGERED_WORKFLOW_ID")
-			startedBuild, err := app.StartBuild(
-				workflow,
-				tryInjectNewParamsToBuild(build, buildParam),
-				cfg.BuildNumber,
-				newEnvs,
-			)
-			if err != nil {
-				failf("Failed to start build, error: %s", err)
-			}
-			buildSlugs = append(buildSlugs, startedBuild.BuildSlug)
-			log.Printf("- %s started (https://app.bitrise.io/build/%s)", startedBuild.TriggeredWorkflow, startedBuild.BuildSlug)
+		newEnvs := writeBuildParamsToEnvs(&buildParam, &environments)
+		startedBuild, err := app.StartBuild(
+			buildParam.Workflow,
+			tryInjectNewParamsToBuild(build, buildParam),
+			cfg.BuildNumber,
+			newEnvs,
+		)
+		if err != nil {
+			log.Errorf("- %s %s failed to start, error: %s", buildParam.BuildRegion, buildParam.VendorService, err)
+			return bitrise.StartResponse{}, err
+		}
+		log.Printf("- %s started (https://app.bitrise.io/build/%s)", startedBuild.TriggeredWorkflow, startedBuild.BuildSlug)
+		return startedBuild, nil
+	})
+	report = append(report, kept...)
+
+	startFailed := len(report.withStatus(startStatusFailed)) > 0
+	if startFailed && cfg.StartFailurePolicy != startFailurePolicyContinue {
+		log.Warnf("Rolling back the started builds:")
+		for _, err := range report.rollback(app.AbortBuild) {
+			log.Warnf("%s", err)
 		}
 	}
 
+	log.Infof("Start report:")
+	for _, result := range report {
+		log.Printf("- %s %s: %s %s", result.Region, result.Vendor, result.Status, valueOrDash(result.BuildSlug+result.Error))
+	}
+	summary, err := report.summary()
+	if err != nil {
+		failf("Failed to summarize started builds, error: %s", err)
+	}
+	if err := tools.ExportEnvironmentWithEnvman(envStartReport, summary); err != nil {
+		failf("Failed to export environment variable, error: %s", err)
+	}
+
+	routed := newRouterSummary(plan, spec, report)
+	writeRouterSummary(routed, cfg.DeployDir)
+
+	children := report.children()
+
 	// Export the forked buildslug
-	if err := tools.ExportEnvironmentWithEnvman(envBuildSlugs, strings.Join(buildSlugs, "\n")); err != nil {
+	if err := tools.ExportEnvironmentWithEnvman(envBuildSlugs, strings.Join(children.slugs(), "\n")); err != nil {
 		failf("Failed to export environment variable, error: %s", err)
 	}
+
+	slack := newSlackNotifier(string(cfg.SlackWebhookURL), cfg.SlackChannel)
+	if slack.enabled() && len(children) > 0 {
+		notifySlack(slack, slackStartedText(ref, buildParam, "https://app.bitrise.io/build/"+cfg.BuildSlug, cfg.BuildNumber, children))
+	}
+
+	if startFailed && cfg.StartFailurePolicy != startFailurePolicyContinue {
+		failf("%d of %d builds failed to start, the started ones were aborted", len(report.withStatus(startStatusFailed)), len(report))
+	}
+
+	if cfg.WaitForBuilds && len(children) > 0 {
+		waitForChildren(app, children, policy, waitTimeout, routed, slack, cfg)
+	}
+	if startFailed {
+		failf("%d of %d builds failed to start", len(report.withStatus(startStatusFailed)), len(report))
+	}
+}
+
+// waitForChildren polls the forked builds until they finish, failing the parent if any of them failed.
+// The builds still running are aborted once the policy gives up on the run,
+// the artifacts matching artifact_patterns are collected from every finished build.
+func waitForChildren(app bitrise.App, children childBuilds, policy abortPolicy, timeout time.Duration, routed *routerSummary, slack slackNotifier, cfg Config) {
+	log.Infof("Waiting for builds:")
+	failures := 0
+	aborted := false
+	opts := bitrise.WaitOptions{Timeout: timeout}
+	result, waitErr := app.WaitForBuilds(context.Background(), children.slugs(), opts, func(build bitrise.Build, previous bitrise.BuildStatus) {
+		child := children.update(build)
+		if child == nil {
+			return
+		}
+		log.Printf("- %s %s (#%d): %s -> %s", child.Region, child.Vendor, build.BuildNumber, previous, build.Status)
+
+		if !child.finished() || child.succeeded() || aborted {
+			return
+		}
+		failures++
+		if !policy.shouldAbort(failures) {
+			return
+		}
+		aborted = true
+		reason := abortReason(child)
+		for _, sibling := range children.running() {
+			log.Warnf("Aborting %s %s (#%d): %s", sibling.Region, sibling.Vendor, sibling.BuildNumber, reason)
+			if err := app.AbortBuild(sibling.BuildSlug, reason); err != nil {
+				log.Warnf("Failed to abort build %s, error: %s", sibling.BuildSlug, err)
+			}
+		}
+	})
+	children.record(result)
+
+	summary, err := children.summary()
+	if err != nil {
+		failf("Failed to summarize builds, error: %s", err)
+	}
+	if err := tools.ExportEnvironmentWithEnvman(envChildResults, summary); err != nil {
+		failf("Failed to export environment variable, error: %s", err)
+	}
+	routed.setResults(children)
+	writeRouterSummary(routed, cfg.DeployDir)
+	if slack.enabled() && waitErr == nil {
+		notifySlack(slack, slackFinishedText(routed.Ref, children, result.TimedOut))
+	}
+
+	if patterns := parseArtifactPatterns(cfg.ArtifactPatterns); len(patterns) > 0 && len(children.running()) == 0 {
+		collectChildArtifacts(app, children, patterns, cfg.DeployDir)
+	}
+
+	if failed := children.failed(); len(failed) > 0 {
+		if cfg.DeployDir != "" {
+			if err := os.MkdirAll(cfg.DeployDir, 0755); err != nil {
+				log.Warnf("Failed to create deploy dir, error: %s", err)
+			}
+		}
+		for _, path := range reportFailedLogs(failed, app.FetchBuildLog, cfg.FailedLogLines, cfg.DeployDir) {
+			log.Printf("Saved log: %s", path)
+		}
+		for _, child := range failed {
+			log.Errorf("- %s %s (#%d) %s: %s", child.Region, child.Vendor, child.BuildNumber, child.StatusText, child.BuildURL)
+		}
+		failf("%d of %d forked builds failed or were aborted", len(failed), len(children))
+	}
+	if waitErr != nil {
+		failf("Failed to wait for builds, error: %s", waitErr)
+	}
+	if result.TimedOut {
+		for _, child := range children.timedOut() {
+			log.Errorf("- %s %s (#%d) still %s after %s: %s", child.Region, child.Vendor, child.BuildNumber, child.Status, time.Duration(child.DurationSeconds)*time.Second, child.BuildURL)
+		}
+		failf("Timed out after %s waiting for %d of %d forked builds", timeout, len(children.timedOut()), len(children))
+	}
+	log.Donef("All %d forked builds succeeded", len(children))
+}
+
+// collectChildArtifacts downloads the matching artifacts of the finished forked builds to the deploy dir.
+// Failures only warn, the results and logs of the forked builds are reported either way.
+func collectChildArtifacts(app bitrise.App, children childBuilds, patterns []string, deployDir string) {
+	log.Infof("Collecting artifacts:")
+	if err := os.MkdirAll(deployDir, 0755); err != nil {
+		log.Warnf("Failed to create deploy dir, error: %s", err)
+		return
+	}
+	artifacts, errs := collectArtifacts(app, children, patterns, deployDir)
+	for _, err := range errs {
+		log.Warnf("%s", err)
+	}
+	indexPath, err := writeArtifactIndex(artifacts, deployDir)
+	if err != nil {
+		log.Warnf("Failed to write artifact index, error: %s", err)
+		return
+	}
+	if err := tools.ExportEnvironmentWithEnvman(envArtifactIndex, indexPath); err != nil {
+		log.Warnf("Failed to export environment variable, error: %s", err)
+	}
+	log.Printf("%d artifacts collected, index: %s", len(artifacts), indexPath)
+}
+
+// notifySlack posts to the webhook, a failure only warns as Slack is informational
+func notifySlack(slack slackNotifier, text string) {
+	if err := slack.post(text); err != nil {
+		log.Warnf("Failed to notify Slack, error: %s", err)
+	}
+}
+
+// writeRouterSummary writes the summary to the deploy dir, a failure only warns as the builds are already routed
+func writeRouterSummary(routed *routerSummary, deployDir string) {
+	if deployDir == "" {
+		log.Debugf("BITRISE_DEPLOY_DIR is not set, skipping router summary")
+		return
+	}
+	paths, err := routed.write(deployDir)
+	if err != nil {
+		log.Warnf("Failed to write router summary, error: %s", err)
+		return
+	}
+	log.Printf("Router summary: %s", strings.Join(paths, ", "))
 }
 
 func writeBuildParamsToEnvs(buildParams *BuildParams, src *[]bitrise.Environment) []bitrise.Environment {
@@ -138,13 +437,16 @@ func writeBuildParamsToEnvs(buildParams *BuildParams, src *[]bitrise.Environment
 			field := rType.Field(i)
 			fieldValue := rValue.Field(i)
 			key := field.Tag.Get("env")
+			if key == "-" {
+				continue
+			}
 			value := fmt.Sprintf("%v", fieldValue.Interface())
 			if err := tools.ExportEnvironmentWithEnvman(key, value); err != nil {
 				failf("Failed to export environment variable, error: %s", err)
 			}
 		}
 	} else {
-		copy(newEnvs, *src)
+		newEnvs = append(newEnvs, *src...)
 		for i := 0; i < rType.NumField(); i++ {
 			field := rType.Field(i)
 			fieldValue := rValue.Field(i)
@@ -175,3 +477,55 @@ func tryInjectNewParamsToBuild(build bitrise.Build, newParams BuildParams) json.
 	result, _ := json.Marshal(params)
 	return result
 }
+
+// parseEnvKeys splits a newline separated list of env keys, the keys may be written as $KEY
+func parseEnvKeys(s string) []string {
+	var keys []string
+	for _, line := range strings.Split(s, "\n") {
+		if key := strings.TrimPrefix(strings.TrimSpace(line), "$"); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// createEnvs returns the envs of the parent forwarded to the forked builds
+func createEnvs(environmentKeys string) []bitrise.Environment {
+	var envs []bitrise.Environment
+	for _, key := range parseEnvKeys(environmentKeys) {
+		envs = append(envs, bitrise.Environment{
+			MappedTo: key,
+			Value:    os.Getenv(key),
+		})
+	}
+	return envs
+}
+
+// checkSensitiveEnvs refuses forwarding envs which look like secrets, unless their key is allowed.
+// An env is sensitive if its key looks like one of a secret, or its value is one of the given secrets.
+func checkSensitiveEnvs(envs []bitrise.Environment, allowedKeys string, secrets ...string) error {
+	allowed := make(map[string]bool)
+	for _, key := range parseEnvKeys(allowedKeys) {
+		allowed[key] = true
+	}
+
+	var refused []string
+	for _, env := range envs {
+		if allowed[env.MappedTo] {
+			continue
+		}
+		sensitive := sensitiveKeyExp.MatchString(env.MappedTo)
+		for _, secret := range secrets {
+			if secret != "" && env.Value == secret {
+				sensitive = true
+			}
+		}
+		if sensitive {
+			refused = append(refused, env.MappedTo)
+		}
+	}
+	if len(refused) > 0 {
+		return fmt.Errorf("refusing to forward sensitive envs %s, add them to allowed_sensitive_envs to forward them anyway", strings.Join(refused, ", "))
+	}
+	return nil
+}
@@ -1,13 +1,21 @@
 package main
 
 import (
+	"os"
 	"reflect"
 	"testing"
 
-	"github.com/bitrise-steplib/bitrise-step-build-router-start/bitrise"
+	"github.com/vielasis/bitrise-step-build-router-start/bitrise"
 )
 
 func Test_createEnvs(t *testing.T) {
+	// set by the test workflow of bitrise.yml
+	for key, value := range map[string]string{"ENV_1": "1", "ENV_2": "2", "ENV_3": "3", "ENV_4": "4"} {
+		if err := os.Setenv(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
 	tests := []struct {
 		name            string
 		environmentKeys string
@@ -76,3 +84,43 @@ func Test_createEnvs(t *testing.T) {
 		})
 	}
 }
+
+func Test_checkSensitiveEnvs(t *testing.T) {
+	tests := []struct {
+		name    string
+		envs    []bitrise.Environment
+		allowed string
+		wantErr bool
+	}{
+		{
+			name: "plain envs",
+			envs: []bitrise.Environment{{MappedTo: "ENV_1", Value: "1"}, {MappedTo: "RELEASE_NOTES", Value: "fixes"}},
+		},
+		{
+			name:    "secret key",
+			envs:    []bitrise.Environment{{MappedTo: "ENV_1", Value: "1"}, {MappedTo: "SLACK_TOKEN", Value: "xoxb"}},
+			wantErr: true,
+		},
+		{
+			name:    "access token value",
+			envs:    []bitrise.Environment{{MappedTo: "MY_ENV", Value: "access-token"}},
+			wantErr: true,
+		},
+		{
+			name:    "allowed secret key",
+			envs:    []bitrise.Environment{{MappedTo: "KEYSTORE_URL", Value: "https://example.com/keystore"}},
+			allowed: "$KEYSTORE_URL\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSensitiveEnvs(tt.envs, tt.allowed, "access-token")
+			if tt.wantErr && err == nil {
+				t.Errorf("checkSensitiveEnvs() expected to return error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("checkSensitiveEnvs() err = %v", err)
+			}
+		})
+	}
+}
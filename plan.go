@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	planRoleParent = "parent"
+	planRoleFork   = "fork"
+)
+
+// planEntry is a single build of the matrix, either run by the parent or forked
+type planEntry struct {
+	Index       int         `json:"index"`
+	Role        string      `json:"role"`
+	Workflow    string      `json:"workflow"`
+	Region      string      `json:"region"`
+	BuildParams BuildParams `json:"build_params"`
+}
+
+// buildPlan is what the router would do for a git reference
+type buildPlan struct {
+	Ref     gitRef      `json:"ref"`
+	Trace   routeTrace  `json:"trace"`
+	Entries []planEntry `json:"entries"`
+}
+
+// routeWorkflows defaults the workflow of the builds to the triggered one and moves the first build
+// running the triggered workflow to the front, as the parent cannot switch workflows.
+// It fails if no build runs the triggered workflow, naming the workflows to trigger instead.
+func routeWorkflows(buildParams []BuildParams, triggered string) ([]BuildParams, error) {
+	routed := make([]BuildParams, 0, len(buildParams))
+	parent := -1
+	var workflows []string
+	seen := make(map[string]bool)
+	for i, buildParam := range buildParams {
+		if buildParam.Workflow == "" {
+			buildParam.Workflow = triggered
+		}
+		if parent == -1 && buildParam.Workflow == triggered {
+			parent = i
+		}
+		if !seen[buildParam.Workflow] {
+			seen[buildParam.Workflow] = true
+			workflows = append(workflows, buildParam.Workflow)
+		}
+		routed = append(routed, buildParam)
+	}
+	if parent == -1 {
+		return nil, fmt.Errorf("no build is routed to the triggered workflow %s, trigger %s instead", triggered, strings.Join(workflows, " or "))
+	}
+	if parent > 0 {
+		routed = append(append([]BuildParams{routed[parent]}, routed[:parent]...), routed[parent+1:]...)
+	}
+	return routed, nil
+}
+
+// unknownWorkflows returns the workflows of the forked builds missing from the workflows of the app
+func unknownWorkflows(buildParams []BuildParams, workflows []string) []string {
+	known := make(map[string]bool)
+	for _, workflow := range workflows {
+		known[workflow] = true
+	}
+	var unknown []string
+	for i, buildParam := range buildParams {
+		if i == 0 || known[buildParam.Workflow] {
+			continue
+		}
+		known[buildParam.Workflow] = true
+		unknown = append(unknown, buildParam.Workflow)
+	}
+	return unknown
+}
+
+// newBuildPlan lists the routed builds, the first one is run by the parent
+func newBuildPlan(ref gitRef, trace routeTrace, buildParams []BuildParams) buildPlan {
+	plan := buildPlan{Ref: ref, Trace: trace}
+	for i, buildParam := range buildParams {
+		role := planRoleFork
+		if i == 0 {
+			role = planRoleParent
+		}
+		plan.Entries = append(plan.Entries, planEntry{
+			Index:       i,
+			Role:        role,
+			Workflow:    buildParam.Workflow,
+			Region:      buildParam.BuildRegion,
+			BuildParams: buildParam,
+		})
+	}
+	return plan
+}
+
+// printTable writes the rule trace and a human readable table of the plan
+func (plan buildPlan) printTable(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "Rule trace:"); err != nil {
+		return err
+	}
+	for _, line := range plan.Trace {
+		if _, err := fmt.Fprintf(w, "- %s\n", line); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintln(w); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "#\tROLE\tWORKFLOW\tREGION\tVENDOR\tBUILD TASK\tPACKAGE\tTAG\tCOMMIT"); err != nil {
+		return err
+	}
+	for _, entry := range plan.Entries {
+		params := entry.BuildParams
+		if _, err := fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			entry.Index,
+			entry.Role,
+			valueOrDash(entry.Workflow),
+			entry.Region,
+			params.VendorService,
+			params.GradleBuildTask,
+			params.PackageName,
+			valueOrDash(params.NewTag),
+			valueOrDash(strings.TrimSpace(params.NewCommitHash)),
+		); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// printJSON writes the plan as an indented JSON document
+func (plan buildPlan) printJSON(w io.Writer) error {
+	b, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+func valueOrDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
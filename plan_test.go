@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_routeWorkflows(t *testing.T) {
+	// skip git rev-parse
+	require.NoError(t, os.Setenv("BITRISE_GIT_COMMIT", "abcdef"))
+	defer func() {
+		require.NoError(t, os.Unsetenv("BITRISE_GIT_COMMIT"))
+	}()
+
+	tests := []struct {
+		name          string
+		tag           string
+		rules         []WorkflowRule
+		wantWorkflows []string
+		wantTasks     []string
+		wantErr       bool
+		wantUnknown   []string
+	}{
+		{
+			name:          "triggered workflow",
+			tag:           "2.4.0-RC1-SG-AU",
+			wantWorkflows: []string{"release", "release"},
+			wantTasks:     []string{"assembleSingaporeGmsQa", "assembleAustraliaGmsQa"},
+		},
+		{
+			name:          "vendor and region rules",
+			tag:           "2.4.0-RC1-SG-AU-JP-GMS-HMS",
+			rules:         []WorkflowRule{{Vendor: "HMS", Workflow: "huawei-release"}, {Region: "JP", Workflow: "release-jp"}},
+			wantWorkflows: []string{"release", "huawei-release", "release", "huawei-release", "release-jp", "huawei-release"},
+			wantTasks:     []string{"assembleSingaporeGmsQa", "assembleSingaporeHmsQa", "assembleAustraliaGmsQa", "assembleAustraliaHmsQa", "assembleJapanGmsQa", "assembleJapanHmsQa"},
+			wantUnknown:   []string{"release-jp"},
+		},
+		{
+			name:          "parent moves to the triggered workflow",
+			tag:           "2.4.0-RC1-SG-AU",
+			rules:         []WorkflowRule{{Region: "SG", Workflow: "release-sg"}},
+			wantWorkflows: []string{"release", "release-sg"},
+			wantTasks:     []string{"assembleAustraliaGmsQa", "assembleSingaporeGmsQa"},
+		},
+		{
+			name:    "build type rule",
+			tag:     "2.4.0-AU",
+			rules:   []WorkflowRule{{BuildType: "release", Workflow: "store-release"}},
+			wantErr: true,
+		},
+		{
+			name:    "region rule",
+			tag:     "2.4.0-JP",
+			rules:   []WorkflowRule{{Region: "JP", Workflow: "release-jp"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routerCfg := testRouterConfig()
+			routerCfg.Workflows.Rules = tt.rules
+			var trace routeTrace
+			buildParams, err := generateBuildParams(&routerCfg, gitRef{Tag: tt.tag}, &trace)
+			require.NoError(t, err, "generateBuildParams() err")
+
+			got, err := routeWorkflows(buildParams, "release")
+			if tt.wantErr {
+				require.Error(t, err, "routeWorkflows() expected to return error")
+				return
+			}
+			require.NoError(t, err, "routeWorkflows() err")
+			var workflows, tasks []string
+			for _, buildParam := range got {
+				workflows = append(workflows, buildParam.Workflow)
+				tasks = append(tasks, buildParam.GradleBuildTask)
+			}
+			require.Equal(t, tt.wantWorkflows, workflows, "routeWorkflows() workflows")
+			require.Equal(t, tt.wantTasks, tasks, "routeWorkflows() tasks")
+			plan := newBuildPlan(gitRef{Tag: tt.tag}, trace, got)
+			for i, entry := range plan.Entries {
+				require.Equal(t, tt.wantWorkflows[i], entry.Workflow, "newBuildPlan() workflow")
+			}
+			require.Equal(t, tt.wantUnknown, unknownWorkflows(got, []string{"release", "huawei-release", "release-sg"}), "unknownWorkflows()")
+		})
+	}
+}
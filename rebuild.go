@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vielasis/bitrise-step-build-router-start/bitrise"
+)
+
+const (
+	rebuildPolicySkip    = "skip"    // keep the earlier build, without waiting for it
+	rebuildPolicyReuse   = "reuse"   // keep the earlier build and wait for it like a started one
+	rebuildPolicyReplace = "replace" // abort the earlier build if it still runs and fork a new one
+
+	// earlier builds looked up when the parent is rebuilt
+	rebuildLookupLimit  = 50
+	rebuildLookupPages  = 4 // the API cannot filter by commit or tag, tag builds are filtered while paging
+	rebuildLookupWindow = 30 * 24 * time.Hour
+)
+
+func validateRebuildPolicy(policy string) error {
+	switch policy {
+	case "", rebuildPolicySkip, rebuildPolicyReuse, rebuildPolicyReplace:
+		return nil
+	default:
+		return fmt.Errorf("unknown rebuild policy %s, expected %s, %s or %s", policy, rebuildPolicySkip, rebuildPolicyReuse, rebuildPolicyReplace)
+	}
+}
+
+// forkedRef is what a build is forked with, an earlier fork only counts for the same tag, branch and workflow,
+// e.g. the forks of 2.4.0-RC1 are not reused for 2.4.0-RC2 pushed on the same commit
+type forkedRef struct {
+	Tag      string
+	Branch   string
+	Workflow string
+}
+
+// forkedRefOf returns what the build param is forked with by the parent
+func forkedRefOf(parent bitrise.Build, buildParam BuildParams) forkedRef {
+	tag := parent.Tag
+	if buildParam.NewTag != "" {
+		tag = buildParam.NewTag
+	}
+	return forkedRef{Tag: tag, Branch: parent.Branch, Workflow: buildParam.Workflow}
+}
+
+// childKey identifies a forked build of a commit by the envs the router injects into it and what it was forked with
+func childKey(a2Code, vendor, buildType string, ref forkedRef) string {
+	return fmt.Sprintf("%s/%s/%s@%s/%s/%s", a2Code, vendor, buildType, ref.Tag, ref.Branch, ref.Workflow)
+}
+
+// earlierRunLookup narrows the listed builds to the earlier runs of the parent, i.e. builds of its workflow,
+// ref and commit triggered before it. The limit leaves room for the parent itself.
+func earlierRunLookup(parent bitrise.Build, now time.Time) bitrise.ListBuildsParams {
+	return bitrise.ListBuildsParams{
+		Workflow:        parent.TriggeredWorkflow,
+		Branch:          parent.Branch,
+		Tag:             parent.Tag,
+		CommitHash:      parent.CommitHash,
+		TriggeredAfter:  now.Add(-rebuildLookupWindow),
+		TriggeredBefore: parent.TriggeredAt,
+		Limit:           2,
+		MaxPages:        rebuildLookupPages,
+	}
+}
+
+// isRebuild reports whether an earlier run of the parent is listed, only a rebuilt parent looks up its earlier forks
+func isRebuild(builds []bitrise.Build, parent bitrise.Build) bool {
+	for _, build := range builds {
+		if build.Slug != parent.Slug {
+			return true
+		}
+	}
+	return false
+}
+
+// rebuildLookup narrows the listed builds to the ones the earlier runs of the parent could have forked
+func rebuildLookup(parent bitrise.Build, buildParams []BuildParams, now time.Time) bitrise.ListBuildsParams {
+	lookup := bitrise.ListBuildsParams{
+		Branch:         parent.Branch,
+		CommitHash:     parent.CommitHash,
+		TriggeredAfter: now.Add(-rebuildLookupWindow),
+		Limit:          rebuildLookupLimit,
+		MaxPages:       rebuildLookupPages,
+	}
+	for i, buildParam := range buildParams {
+		if i == 0 {
+			lookup.Workflow = buildParam.Workflow
+		} else if buildParam.Workflow != lookup.Workflow {
+			// forks run several workflows, the API filters a single one
+			lookup.Workflow = ""
+			break
+		}
+	}
+	return lookup
+}
+
+// findEarlierChildren returns the builds forked for the commit of the parent by earlier runs of the router,
+// by childKey. Failed and aborted builds are ignored, they are forked again.
+func findEarlierChildren(builds []bitrise.Build, parent bitrise.Build) map[string]bitrise.Build {
+	children := make(map[string]bitrise.Build)
+	if parent.CommitHash == "" {
+		return children
+	}
+	// builds are listed newest first, the newest fork of a region wins
+	for _, build := range builds {
+		if build.Slug == parent.Slug || build.CommitHash != parent.CommitHash || (build.Status != bitrise.BuildStatusRunning && build.Status != bitrise.BuildStatusSuccess) {
+			continue
+		}
+		envs := build.Environments()
+		if envs["SOURCE_BITRISE_BUILD_NUMBER"] == "" {
+			// not forked by the router
+			continue
+		}
+		ref := forkedRef{Tag: build.Tag, Branch: build.Branch, Workflow: build.TriggeredWorkflow}
+		key := childKey(envs["ALPHA_2_CODE"], envs["VENDOR_SVC"], envs["BUILD_TYPE_NAME"], ref)
+		if _, ok := children[key]; !ok {
+			children[key] = build
+		}
+	}
+	return children
+}
+
+// planRebuild splits the builds the parent forks into the ones to start and the earlier forks kept by the policy.
+// Earlier forks replaced by the policy are returned to be aborted if they still run.
+func planRebuild(buildParams []BuildParams, parent bitrise.Build, earlier map[string]bitrise.Build, policy string) (toStart []BuildParams, kept startReport, replaced []bitrise.Build) {
+	for _, buildParam := range buildParams {
+		build, ok := earlier[childKey(buildParam.Alpha2Code, buildParam.VendorService, buildParam.BuildTypeName, forkedRefOf(parent, buildParam))]
+		if !ok {
+			toStart = append(toStart, buildParam)
+			continue
+		}
+
+		switch policy {
+		case rebuildPolicyReplace:
+			if build.Status == bitrise.BuildStatusRunning {
+				replaced = append(replaced, build)
+			}
+			toStart = append(toStart, buildParam)
+		default:
+			status := startStatusReused
+			if policy == rebuildPolicySkip {
+				status = startStatusDuplicate
+			}
+			kept = append(kept, &startResult{
+				Region:      buildParam.BuildRegion,
+				A2Code:      buildParam.Alpha2Code,
+				Vendor:      buildParam.VendorService,
+				Status:      status,
+				BuildSlug:   build.Slug,
+				BuildNumber: int(build.BuildNumber),
+				buildParam:  buildParam,
+				started:     bitrise.StartResponse{BuildSlug: build.Slug, BuildNumber: int(build.BuildNumber)},
+			})
+		}
+	}
+	return toStart, kept, replaced
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vielasis/bitrise-step-build-router-start/bitrise"
+)
+
+func testForkedBuild(slug string, number int64, status bitrise.BuildStatus, commit, tag, a2Code string) bitrise.Build {
+	params := fmt.Sprintf(`{"commit_hash": %q, "environments": [
+		{"mapped_to": "SOURCE_BITRISE_BUILD_NUMBER", "value": "100"},
+		{"mapped_to": "ALPHA_2_CODE", "value": %q},
+		{"mapped_to": "VENDOR_SVC", "value": "GMS"},
+		{"mapped_to": "BUILD_TYPE_NAME", "value": "qa"}
+	]}`, commit, a2Code)
+	return bitrise.Build{Slug: slug, BuildNumber: number, Status: status, CommitHash: commit, Tag: tag, TriggeredWorkflow: "release", OriginalBuildParams: json.RawMessage(params)}
+}
+
+func Test_planRebuild(t *testing.T) {
+	builds := []bitrise.Build{
+		testForkedBuild("au-rc2", 104, 0, "abcdef", "2.4.0-RC2-AU", "AU"),
+		testForkedBuild("au-new", 103, 0, "abcdef", "2.4.0-RC1-AU", "AU"),
+		testForkedBuild("jp-failed", 102, 2, "abcdef", "2.4.0-RC1-JP", "JP"),
+		testForkedBuild("au-old", 101, 1, "abcdef", "2.4.0-RC1-AU", "AU"),
+		testForkedBuild("id-other-commit", 99, 1, "123456", "2.4.0-RC1-ID", "ID"),
+		{Slug: "manual", BuildNumber: 98, CommitHash: "abcdef", OriginalBuildParams: json.RawMessage(`{}`)},
+	}
+	buildParams := []BuildParams{
+		{BuildRegion: "Australia", Alpha2Code: "AU", VendorService: "GMS", BuildTypeName: "qa", NewTag: "2.4.0-RC1-AU", Workflow: "release"},
+		{BuildRegion: "Japan", Alpha2Code: "JP", VendorService: "GMS", BuildTypeName: "qa", NewTag: "2.4.0-RC1-JP", Workflow: "release"},
+		{BuildRegion: "Indonesia", Alpha2Code: "ID", VendorService: "GMS", BuildTypeName: "qa", NewTag: "2.4.0-RC1-ID", Workflow: "release"},
+	}
+
+	tests := []struct {
+		name         string
+		policy       string
+		workflow     string
+		wantStart    []string
+		wantKept     []string
+		wantReplaced []string
+		wantChildren []string
+	}{
+		{
+			name:         "reuse by default",
+			policy:       "",
+			wantStart:    []string{"JP", "ID"},
+			wantKept:     []string{"au-new reused"},
+			wantChildren: []string{"au-new"},
+		},
+		{
+			name:      "skip",
+			policy:    rebuildPolicySkip,
+			wantStart: []string{"JP", "ID"},
+			wantKept:  []string{"au-new duplicate"},
+		},
+		{
+			name:         "replace",
+			policy:       rebuildPolicyReplace,
+			wantStart:    []string{"AU", "JP", "ID"},
+			wantReplaced: []string{"au-new"},
+		},
+		{
+			name:      "forks of another workflow are not reused",
+			policy:    rebuildPolicyReuse,
+			workflow:  "store-release",
+			wantStart: []string{"AU", "JP", "ID"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := make([]BuildParams, len(buildParams))
+			copy(params, buildParams)
+			if tt.workflow != "" {
+				for i := range params {
+					params[i].Workflow = tt.workflow
+				}
+			}
+			parent := bitrise.Build{Slug: "parent", CommitHash: "abcdef", Tag: "2.4.0-RC1-AU-JP-ID"}
+			toStart, kept, replaced := planRebuild(params, parent, findEarlierChildren(builds, parent), tt.policy)
+
+			var start, keptSlugs, replacedSlugs []string
+			for _, buildParam := range toStart {
+				start = append(start, buildParam.Alpha2Code)
+			}
+			for _, result := range kept {
+				keptSlugs = append(keptSlugs, result.BuildSlug+" "+result.Status)
+			}
+			for _, build := range replaced {
+				replacedSlugs = append(replacedSlugs, build.Slug)
+			}
+			require.Equal(t, tt.wantStart, start, "planRebuild() to start")
+			require.Equal(t, tt.wantKept, keptSlugs, "planRebuild() kept")
+			require.Equal(t, tt.wantReplaced, replacedSlugs, "planRebuild() replaced")
+			require.Equal(t, tt.wantChildren, kept.children().slugs(), "startReport.children()")
+		})
+	}
+}
+
+func Test_planRebuild_otherRef(t *testing.T) {
+	tests := []struct {
+		name      string
+		parent    bitrise.Build
+		earlier   bitrise.Build
+		wantStart bool
+	}{
+		{
+			name:    "same tag",
+			parent:  bitrise.Build{Slug: "parent", CommitHash: "abcdef", Tag: "2.4.0-RC1-AU"},
+			earlier: bitrise.Build{Tag: "2.4.0-RC1-AU"},
+		},
+		{
+			name:      "next rc on the same commit",
+			parent:    bitrise.Build{Slug: "parent", CommitHash: "abcdef", Tag: "2.4.0-RC2-AU"},
+			earlier:   bitrise.Build{Tag: "2.4.0-RC1-AU"},
+			wantStart: true,
+		},
+		{
+			name:    "same branch",
+			parent:  bitrise.Build{Slug: "parent", CommitHash: "abcdef", Branch: "develop"},
+			earlier: bitrise.Build{Branch: "develop"},
+		},
+		{
+			name:      "other branch on the same commit",
+			parent:    bitrise.Build{Slug: "parent", CommitHash: "abcdef", Branch: "feature/AU-login"},
+			earlier:   bitrise.Build{Branch: "develop"},
+			wantStart: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			earlier := testForkedBuild("au", 10, 1, "abcdef", tt.earlier.Tag, "AU")
+			earlier.Branch = tt.earlier.Branch
+			buildParams := []BuildParams{{BuildRegion: "Australia", Alpha2Code: "AU", VendorService: "GMS", BuildTypeName: "qa", Workflow: "release"}}
+
+			toStart, _, _ := planRebuild(buildParams, tt.parent, findEarlierChildren([]bitrise.Build{earlier}, tt.parent), rebuildPolicyReuse)
+			require.Equal(t, tt.wantStart, len(toStart) == 1, "planRebuild() to start")
+		})
+	}
+}
+
+func Test_rebuildLookup(t *testing.T) {
+	now := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	parent := bitrise.Build{CommitHash: "abcdef", Branch: "develop"}
+
+	tests := []struct {
+		name         string
+		workflows    []string
+		wantWorkflow string
+	}{
+		{name: "single workflow", workflows: []string{"release", "release"}, wantWorkflow: "release"},
+		{name: "several workflows", workflows: []string{"release", "huawei-release"}, wantWorkflow: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buildParams []BuildParams
+			for _, workflow := range tt.workflows {
+				buildParams = append(buildParams, BuildParams{Workflow: workflow})
+			}
+			got := rebuildLookup(parent, buildParams, now)
+			require.Equal(t, bitrise.ListBuildsParams{
+				Workflow:       tt.wantWorkflow,
+				Branch:         "develop",
+				CommitHash:     "abcdef",
+				TriggeredAfter: now.Add(-rebuildLookupWindow),
+				Limit:          rebuildLookupLimit,
+				MaxPages:       rebuildLookupPages,
+			}, got, "rebuildLookup()")
+		})
+	}
+}
+
+func Test_isRebuild(t *testing.T) {
+	parent := bitrise.Build{Slug: "parent", TriggeredWorkflow: "release", Tag: "2.4.0-RC2", CommitHash: "abcdef", TriggeredAt: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)}
+
+	tests := []struct {
+		name   string
+		builds []bitrise.Build
+		want   bool
+	}{
+		{name: "first run", builds: []bitrise.Build{parent}, want: false},
+		{name: "nothing listed", builds: nil, want: false},
+		{name: "earlier run", builds: []bitrise.Build{parent, {Slug: "earlier", TriggeredWorkflow: "release", Tag: "2.4.0-RC2", CommitHash: "abcdef"}}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, isRebuild(tt.builds, parent), "isRebuild()")
+		})
+	}
+
+	lookup := earlierRunLookup(parent, parent.TriggeredAt)
+	require.Equal(t, "2.4.0-RC2", lookup.Tag, "earlierRunLookup() tag")
+	require.Equal(t, parent.TriggeredAt, lookup.TriggeredBefore, "earlierRunLookup() triggered before")
+	require.Equal(t, rebuildLookupPages, lookup.MaxPages, "earlierRunLookup() max pages")
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vielasis/bitrise-step-build-router-start/bitrise"
+)
+
+// slackMessage is the payload of an incoming webhook
+type slackMessage struct {
+	Channel string `json:"channel,omitempty"` // only honoured by legacy webhooks, others post to their own channel
+	Text    string `json:"text"`
+}
+
+// slackNotifier posts to a Slack incoming webhook.
+// Webhooks cannot edit what they posted, so the results are posted as a follow-up message.
+type slackNotifier struct {
+	WebhookURL string
+	Channel    string
+	client     *http.Client
+}
+
+func newSlackNotifier(webhookURL, channel string) slackNotifier {
+	return slackNotifier{WebhookURL: webhookURL, Channel: channel, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func validateSlackWebhookURL(webhookURL string) error {
+	if webhookURL == "" {
+		return nil
+	}
+	u, err := url.Parse(webhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid slack webhook url, expected an https url")
+	}
+	return nil
+}
+
+// enabled reports whether a webhook is configured
+func (notifier slackNotifier) enabled() bool {
+	return notifier.WebhookURL != ""
+}
+
+func (notifier slackNotifier) post(text string) error {
+	b, err := json.Marshal(slackMessage{Channel: notifier.Channel, Text: text})
+	if err != nil {
+		return err
+	}
+	resp, err := notifier.client.Post(notifier.WebhookURL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("slack webhook returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// slackEscape escapes the control characters of Slack's mrkdwn
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+func slackLink(url string, text string) string {
+	return fmt.Sprintf("<%s|%s>", url, slackEscape(text))
+}
+
+// slackChildLine describes a forked build, e.g. ":flag-au: Australia GMS qa <url|#12>"
+func slackChildLine(child *childBuild) string {
+	return joinIgnoreEmpty([]string{
+		child.Flag,
+		slackEscape(child.Region),
+		slackEscape(child.Vendor),
+		slackEscape(child.BuildType),
+		slackLink(child.BuildURL, fmt.Sprintf("#%d", child.BuildNumber)),
+	}, " ")
+}
+
+// slackStartedText lists the builds forked by the parent, one line per build
+func slackStartedText(ref gitRef, parent BuildParams, parentURL string, parentNumber string, children childBuilds) string {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("Routed `%s` in %s, forked %d builds:",
+		slackEscape(ref.token()), slackLink(parentURL, "build #"+parentNumber), len(children)))
+	lines = append(lines, joinIgnoreEmpty([]string{
+		parent.SlackFlag,
+		slackEscape(parent.BuildRegion),
+		slackEscape(parent.VendorService),
+		slackEscape(parent.BuildTypeName),
+		"(this build)",
+	}, " "))
+	for _, child := range children {
+		lines = append(lines, slackChildLine(child))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// slackFinishedText lists the final status of the forked builds
+func slackFinishedText(ref gitRef, children childBuilds, timedOut bool) string {
+	failed := children.failed()
+	var header string
+	switch {
+	case timedOut:
+		header = fmt.Sprintf(":hourglass: Timed out waiting for %d of %d builds forked for `%s`:", len(children.timedOut()), len(children), slackEscape(ref.token()))
+	case len(failed) > 0:
+		header = fmt.Sprintf(":x: %d of %d builds forked for `%s` failed:", len(failed), len(children), slackEscape(ref.token()))
+	default:
+		header = fmt.Sprintf(":white_check_mark: All %d builds forked for `%s` succeeded:", len(children), slackEscape(ref.token()))
+	}
+
+	lines := []string{header}
+	for _, child := range children {
+		lines = append(lines, fmt.Sprintf("%s %s", slackStatusEmoji(child), slackChildLine(child)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func slackStatusEmoji(child *childBuild) string {
+	switch child.Status {
+	case bitrise.BuildStatusSuccess, bitrise.BuildStatusAbortedWithSuccess:
+		return ":white_check_mark:"
+	case bitrise.BuildStatusFailed:
+		return ":x:"
+	case bitrise.BuildStatusAborted:
+		return ":no_entry_sign:"
+	default:
+		return ":hourglass:"
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vielasis/bitrise-step-build-router-start/bitrise"
+)
+
+func Test_slackNotifier_post(t *testing.T) {
+	var received []slackMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/hook" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte("no_service"))
+			return
+		}
+		b, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		var message slackMessage
+		require.NoError(t, json.Unmarshal(b, &message))
+		received = append(received, message)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		channel string
+		want    []slackMessage
+		wantErr string
+	}{
+		{name: "webhook channel", path: "/hook", want: []slackMessage{{Text: "hello"}}},
+		{name: "channel override", path: "/hook", channel: "#releases", want: []slackMessage{{Channel: "#releases", Text: "hello"}}},
+		{name: "revoked webhook", path: "/revoked", wantErr: "slack webhook returned 404: no_service"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received = nil
+			err := newSlackNotifier(server.URL+tt.path, tt.channel).post("hello")
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr, "slackNotifier.post() err")
+				return
+			}
+			require.NoError(t, err, "slackNotifier.post() err")
+			require.Equal(t, tt.want, received, "slackNotifier.post() payload")
+		})
+	}
+}
+
+func Test_slackText(t *testing.T) {
+	parent := BuildParams{SlackFlag: ":flag-sg:", BuildRegion: "Singapore", VendorService: "GMS", BuildTypeName: "qa"}
+	newChildren := func() childBuilds {
+		var children childBuilds
+		children.add(BuildParams{SlackFlag: ":flag-au:", BuildRegion: "Australia", Alpha2Code: "AU", VendorService: "GMS", BuildTypeName: "qa"}, bitrise.StartResponse{BuildSlug: "au", BuildNumber: 12})
+		children.add(BuildParams{SlackFlag: ":flag-jp:", BuildRegion: "Japan", Alpha2Code: "JP", VendorService: "HMS", BuildTypeName: "qa"}, bitrise.StartResponse{BuildSlug: "jp", BuildNumber: 13})
+		return children
+	}
+	ref := gitRef{Tag: "2.4.0-SG-AU-JP"}
+
+	require.Equal(t, "Routed `2.4.0-SG-AU-JP` in <https://app.bitrise.io/build/parent|build #11>, forked 2 builds:\n"+
+		":flag-sg: Singapore GMS qa (this build)\n"+
+		":flag-au: Australia GMS qa <https://app.bitrise.io/build/au|#12>\n"+
+		":flag-jp: Japan HMS qa <https://app.bitrise.io/build/jp|#13>",
+		slackStartedText(ref, parent, "https://app.bitrise.io/build/parent", "11", newChildren()), "slackStartedText()")
+
+	tests := []struct {
+		name     string
+		builds   []bitrise.Build
+		timedOut bool
+		want     string
+	}{
+		{
+			name:   "succeeded",
+			builds: []bitrise.Build{{Slug: "au", Status: bitrise.BuildStatusSuccess, BuildNumber: 12}, {Slug: "jp", Status: bitrise.BuildStatusSuccess, BuildNumber: 13}},
+			want: ":white_check_mark: All 2 builds forked for `2.4.0-SG-AU-JP` succeeded:\n" +
+				":white_check_mark: :flag-au: Australia GMS qa <https://app.bitrise.io/build/au|#12>\n" +
+				":white_check_mark: :flag-jp: Japan HMS qa <https://app.bitrise.io/build/jp|#13>",
+		},
+		{
+			name:   "failed",
+			builds: []bitrise.Build{{Slug: "au", Status: bitrise.BuildStatusFailed, BuildNumber: 12}, {Slug: "jp", Status: bitrise.BuildStatusAborted, BuildNumber: 13}},
+			want: ":x: 2 of 2 builds forked for `2.4.0-SG-AU-JP` failed:\n" +
+				":x: :flag-au: Australia GMS qa <https://app.bitrise.io/build/au|#12>\n" +
+				":no_entry_sign: :flag-jp: Japan HMS qa <https://app.bitrise.io/build/jp|#13>",
+		},
+		{
+			name:     "timed out",
+			builds:   []bitrise.Build{{Slug: "au", Status: bitrise.BuildStatusSuccess, BuildNumber: 12}},
+			timedOut: true,
+			want: ":hourglass: Timed out waiting for 1 of 2 builds forked for `2.4.0-SG-AU-JP`:\n" +
+				":white_check_mark: :flag-au: Australia GMS qa <https://app.bitrise.io/build/au|#12>\n" +
+				":hourglass: :flag-jp: Japan HMS qa <https://app.bitrise.io/build/jp|#13>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			children := newChildren()
+			for _, build := range tt.builds {
+				children.update(build)
+			}
+			if tt.timedOut {
+				children.record(bitrise.WaitResult{TimedOut: true, Builds: []bitrise.BuildResult{{Slug: "jp", Status: bitrise.BuildStatusRunning, TimedOut: true}}})
+			}
+			require.Equal(t, tt.want, slackFinishedText(ref, children, tt.timedOut), "slackFinishedText()")
+		})
+	}
+}
+
+func Test_validateSlackWebhookURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{name: "not configured", url: ""},
+		{name: "webhook", url: "https://hooks.slack.com/services/T000/B000/XXXX"},
+		{name: "no scheme", url: "hooks.slack.com/services/T000/B000/XXXX", wantErr: true},
+		{name: "not http", url: "ftp://hooks.slack.com/services", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSlackWebhookURL(tt.url)
+			if tt.wantErr {
+				require.Error(t, err, "validateSlackWebhookURL() expected to return error")
+				return
+			}
+			require.NoError(t, err, "validateSlackWebhookURL() err")
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/vielasis/bitrise-step-build-router-start/bitrise"
+)
+
+const (
+	envStartReport = "ROUTER_START_REPORT"
+
+	startFailurePolicyRollback = "rollback"
+	startFailurePolicyContinue = "continue"
+)
+
+const (
+	startStatusStarted   = "started"
+	startStatusFailed    = "failed"
+	startStatusSkipped   = "skipped"   // not started after another start failed
+	startStatusAborted   = "aborted"   // started, then rolled back
+	startStatusReused    = "reused"    // forked by an earlier run of the parent, waited for
+	startStatusDuplicate = "duplicate" // forked by an earlier run of the parent, not waited for
+)
+
+// startResult is the outcome of forking a single build
+type startResult struct {
+	Region      string `json:"region"`
+	A2Code      string `json:"alpha_2_code"`
+	Vendor      string `json:"vendor"`
+	Status      string `json:"status"`
+	BuildSlug   string `json:"build_slug,omitempty"`
+	BuildNumber int    `json:"build_number,omitempty"`
+	Error       string `json:"error,omitempty"`
+
+	buildParam BuildParams
+	started    bitrise.StartResponse
+}
+
+// startReport is the outcome of forking every build, in the order of the build params
+type startReport []*startResult
+
+func validateStartFailurePolicy(policy string) error {
+	switch policy {
+	case "", startFailurePolicyRollback, startFailurePolicyContinue:
+		return nil
+	default:
+		return fmt.Errorf("unknown start failure policy %s, expected %s or %s", policy, startFailurePolicyRollback, startFailurePolicyContinue)
+	}
+}
+
+// startBuilds forks the builds with at most limit starts in flight.
+// With the rollback policy no more builds are started once a start failed.
+func startBuilds(buildParams []BuildParams, limit int, policy string, start func(BuildParams) (bitrise.StartResponse, error)) startReport {
+	if limit < 1 {
+		limit = 1
+	}
+
+	report := make(startReport, len(buildParams))
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := false
+
+	for i, buildParam := range buildParams {
+		report[i] = &startResult{
+			Region:     buildParam.BuildRegion,
+			A2Code:     buildParam.Alpha2Code,
+			Vendor:     buildParam.VendorService,
+			buildParam: buildParam,
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(result *startResult) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			mu.Lock()
+			skip := failed && policy != startFailurePolicyContinue
+			mu.Unlock()
+			if skip {
+				result.Status = startStatusSkipped
+				return
+			}
+
+			started, err := start(result.buildParam)
+			if err != nil {
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+				result.Status = startStatusFailed
+				result.Error = err.Error()
+				return
+			}
+			result.Status = startStatusStarted
+			result.BuildSlug = started.BuildSlug
+			result.BuildNumber = started.BuildNumber
+			result.started = started
+		}(report[i])
+	}
+	wg.Wait()
+	return report
+}
+
+// withStatus returns the results with the given status
+func (report startReport) withStatus(status string) startReport {
+	var results startReport
+	for _, result := range report {
+		if result.Status == status {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// rollback aborts the started builds, marking the ones it could abort
+func (report startReport) rollback(abort func(buildSlug, reason string) error) []error {
+	failed := report.withStatus(startStatusFailed)
+	if len(failed) == 0 {
+		return nil
+	}
+	reason := fmt.Sprintf("aborted by router: failed to start %s", failed[0].A2Code)
+
+	var errs []error
+	for _, result := range report.withStatus(startStatusStarted) {
+		if err := abort(result.BuildSlug, reason); err != nil {
+			errs = append(errs, fmt.Errorf("failed to abort build %s: %s", result.BuildSlug, err))
+			continue
+		}
+		result.Status = startStatusAborted
+	}
+	return errs
+}
+
+// children returns the started and reused builds to wait for
+func (report startReport) children() childBuilds {
+	var children childBuilds
+	for _, result := range report {
+		if result.Status == startStatusStarted || result.Status == startStatusReused {
+			children.add(result.buildParam, result.started)
+		}
+	}
+	return children
+}
+
+// summary returns the report as exported to ROUTER_START_REPORT
+func (report startReport) summary() (string, error) {
+	if report == nil {
+		report = startReport{}
+	}
+	b, err := json.Marshal(report)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
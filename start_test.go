@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vielasis/bitrise-step-build-router-start/bitrise"
+)
+
+func Test_startBuilds(t *testing.T) {
+	buildParams := []BuildParams{
+		{BuildRegion: "Australia", Alpha2Code: "AU", VendorService: "GMS"},
+		{BuildRegion: "Japan", Alpha2Code: "JP", VendorService: "GMS"},
+		{BuildRegion: "Indonesia", Alpha2Code: "ID", VendorService: "GMS"},
+	}
+
+	tests := []struct {
+		name        string
+		limit       int
+		policy      string
+		failing     string
+		wantStatus  []string
+		wantAborted []string
+		wantSlugs   []string
+	}{
+		{
+			name:       "all started",
+			limit:      3,
+			wantStatus: []string{startStatusStarted, startStatusStarted, startStatusStarted},
+			wantSlugs:  []string{"au", "jp", "id"},
+		},
+		{
+			name:        "rollback",
+			limit:       1,
+			policy:      startFailurePolicyRollback,
+			failing:     "JP",
+			wantStatus:  []string{startStatusAborted, startStatusFailed, startStatusSkipped},
+			wantAborted: []string{"au"},
+		},
+		{
+			name:       "continue",
+			limit:      2,
+			policy:     startFailurePolicyContinue,
+			failing:    "JP",
+			wantStatus: []string{startStatusStarted, startStatusFailed, startStatusStarted},
+			wantSlugs:  []string{"au", "id"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := startBuilds(buildParams, tt.limit, tt.policy, func(buildParam BuildParams) (bitrise.StartResponse, error) {
+				if buildParam.Alpha2Code == tt.failing {
+					return bitrise.StartResponse{}, fmt.Errorf("statuscode: 500")
+				}
+				return bitrise.StartResponse{BuildSlug: map[string]string{"AU": "au", "JP": "jp", "ID": "id"}[buildParam.Alpha2Code]}, nil
+			})
+
+			var mu sync.Mutex
+			var aborted []string
+			if tt.policy != startFailurePolicyContinue {
+				errs := report.rollback(func(buildSlug, reason string) error {
+					mu.Lock()
+					defer mu.Unlock()
+					require.Equal(t, "aborted by router: failed to start JP", reason, "startReport.rollback() reason")
+					aborted = append(aborted, buildSlug)
+					return nil
+				})
+				require.Empty(t, errs, "startReport.rollback() errs")
+			}
+			require.Equal(t, tt.wantAborted, aborted, "startReport.rollback()")
+
+			var status []string
+			for _, result := range report {
+				status = append(status, result.Status)
+			}
+			require.Equal(t, tt.wantStatus, status, "startBuilds() status")
+			require.Equal(t, tt.wantSlugs, report.children().slugs(), "startReport.children()")
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	summaryMarkdownFileName = "router-summary.md"
+	summaryJSONFileName     = "router-summary.json"
+)
+
+// summaryChild is a forked build as started and, if waited for, as finished
+type summaryChild struct {
+	Region      string `json:"region"`
+	A2Code      string `json:"alpha_2_code"`
+	Vendor      string `json:"vendor"`
+	BuildType   string `json:"build_type"`
+	StartStatus string `json:"start_status"`
+	BuildSlug   string `json:"build_slug,omitempty"`
+	BuildNumber int64  `json:"build_number,omitempty"`
+	BuildURL    string `json:"build_url,omitempty"`
+	Status      string `json:"status,omitempty"` // final status, only if waited for
+	TimedOut    bool   `json:"timed_out,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// routerSummary is the outcome of a run of the router, written to the deploy dir for release managers
+type routerSummary struct {
+	Ref      gitRef         `json:"ref"`
+	Tag      TagSpec        `json:"tag"`
+	Entries  []planEntry    `json:"entries"`
+	Children []summaryChild `json:"children"`
+	Waited   bool           `json:"waited"`
+}
+
+func newRouterSummary(plan buildPlan, spec TagSpec, report startReport) *routerSummary {
+	summary := &routerSummary{Ref: plan.Ref, Tag: spec, Entries: plan.Entries, Children: []summaryChild{}}
+	for _, result := range report {
+		child := summaryChild{
+			Region:      result.Region,
+			A2Code:      result.A2Code,
+			Vendor:      result.Vendor,
+			BuildType:   result.buildParam.BuildTypeName,
+			StartStatus: result.Status,
+			BuildSlug:   result.BuildSlug,
+			BuildNumber: int64(result.BuildNumber),
+			Error:       result.Error,
+		}
+		if result.BuildSlug != "" {
+			child.BuildURL = fmt.Sprintf("https://app.bitrise.io/build/%s", result.BuildSlug)
+		}
+		summary.Children = append(summary.Children, child)
+	}
+	return summary
+}
+
+// setResults records the final statuses of the waited builds
+func (summary *routerSummary) setResults(children childBuilds) {
+	summary.Waited = true
+	for i, child := range summary.Children {
+		for _, waited := range children {
+			if waited.BuildSlug != child.BuildSlug {
+				continue
+			}
+			summary.Children[i].BuildNumber = waited.BuildNumber
+			summary.Children[i].Status = waited.Status.String()
+			summary.Children[i].TimedOut = waited.TimedOut
+		}
+	}
+}
+
+// markdown renders the summary as tables of the parsed tag, the build matrix and the forked builds
+func (summary routerSummary) markdown() string {
+	var b bytes.Buffer
+	b.WriteString("# Router summary\n\n")
+	if summary.Ref.Tag != "" {
+		fmt.Fprintf(&b, "Tag: `%s`\n\n", summary.Ref.Tag)
+	} else {
+		fmt.Fprintf(&b, "Branch: `%s`\n\n", summary.Ref.Branch)
+	}
+
+	spec := summary.Tag
+	b.WriteString("## Parsed tag\n\n")
+	b.WriteString("| Field | Value |\n|---|---|\n")
+	fmt.Fprintf(&b, "| Version | %s |\n", valueOrDash(spec.Version))
+	fmt.Fprintf(&b, "| RC | %s |\n", valueOrDash(spec.RC))
+	regions := strings.Join(spec.Regions, ", ")
+	if len(spec.Regions) == 0 && summary.Ref.Tag != "" {
+		// tags naming no region build every region, with or without the ALL marker
+		regions = joinIgnoreEmpty([]string{"ALL", strings.Join(spec.Excludes, ", ")}, " except ")
+	}
+	fmt.Fprintf(&b, "| Regions | %s |\n", valueOrDash(regions))
+	fmt.Fprintf(&b, "| Vendors | %s |\n", valueOrDash(strings.Join(spec.Vendors, ", ")))
+	fmt.Fprintf(&b, "| APK | %t |\n", spec.APK)
+	fmt.Fprintf(&b, "| Build type | %s |\n", valueOrDash(string(spec.BuildType)))
+
+	b.WriteString("\n## Build matrix\n\n")
+	b.WriteString("| # | Role | Workflow | Region | Vendor | Build type | Build task | Package | Tag |\n")
+	b.WriteString("|---|---|---|---|---|---|---|---|---|\n")
+	for _, entry := range summary.Entries {
+		params := entry.BuildParams
+		fmt.Fprintf(&b, "| %d | %s | %s | %s | %s | %s | `%s` | %s | %s |\n",
+			entry.Index,
+			entry.Role,
+			valueOrDash(entry.Workflow),
+			entry.Region,
+			params.VendorService,
+			valueOrDash(params.BuildTypeName),
+			params.GradleBuildTask,
+			valueOrDash(params.PackageName),
+			valueOrDash(params.NewTag),
+		)
+	}
+
+	b.WriteString("\n## Forked builds\n\n")
+	if len(summary.Children) == 0 {
+		b.WriteString("No builds were forked.\n")
+		return b.String()
+	}
+	b.WriteString("| Region | Vendor | Build type | Start | Build | Status |\n")
+	b.WriteString("|---|---|---|---|---|---|\n")
+	for _, child := range summary.Children {
+		build := valueOrDash(child.Error)
+		if child.BuildURL != "" {
+			build = fmt.Sprintf("[#%d](%s)", child.BuildNumber, child.BuildURL)
+		}
+		status := child.Status
+		if child.TimedOut {
+			status += " (timed out)"
+		} else if !summary.Waited && child.BuildSlug != "" {
+			status = "not waited for"
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s |\n", child.Region, child.Vendor, valueOrDash(child.BuildType), child.StartStatus, build, valueOrDash(status))
+	}
+	return b.String()
+}
+
+// write writes router-summary.md and router-summary.json to the deploy dir, returning their paths
+func (summary routerSummary) write(deployDir string) ([]string, error) {
+	if err := os.MkdirAll(deployDir, 0755); err != nil {
+		return nil, err
+	}
+	b, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	markdownPath := filepath.Join(deployDir, summaryMarkdownFileName)
+	if err := ioutil.WriteFile(markdownPath, []byte(summary.markdown()), 0644); err != nil {
+		return nil, err
+	}
+	jsonPath := filepath.Join(deployDir, summaryJSONFileName)
+	if err := ioutil.WriteFile(jsonPath, b, 0644); err != nil {
+		return []string{markdownPath}, err
+	}
+	return []string{markdownPath, jsonPath}, nil
+}
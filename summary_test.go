@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vielasis/bitrise-step-build-router-start/bitrise"
+)
+
+func Test_routerSummary_write(t *testing.T) {
+	tests := []struct {
+		name         string
+		waited       []bitrise.Build
+		timedOut     bool
+		wantMarkdown []string
+		wantStatus   []string
+	}{
+		{
+			name: "not waited for",
+			wantMarkdown: []string{
+				"Tag: `2.4.0-RC1-SG-AU`",
+				"| Version | 2.4.0 |",
+				"| RC | RC1 |",
+				"| Regions | SG, AU |",
+				"| 0 | parent | release | Singapore | GMS | qa | `assembleSingaporeGmsQa` |",
+				"| 1 | fork | release | Australia | GMS | qa | `assembleAustraliaGmsQa` |",
+				"| Australia | GMS | qa | started | [#12](https://app.bitrise.io/build/au) | not waited for |",
+			},
+			wantStatus: []string{""},
+		},
+		{
+			name:     "timed out",
+			waited:   []bitrise.Build{{Slug: "au", Status: bitrise.BuildStatusRunning, BuildNumber: 12}},
+			timedOut: true,
+			wantMarkdown: []string{
+				"| Australia | GMS | qa | started | [#12](https://app.bitrise.io/build/au) | running (timed out) |",
+			},
+			wantStatus: []string{"running"},
+		},
+		{
+			name:   "failed",
+			waited: []bitrise.Build{{Slug: "au", Status: bitrise.BuildStatusFailed, BuildNumber: 12}},
+			wantMarkdown: []string{
+				"| Australia | GMS | qa | started | [#12](https://app.bitrise.io/build/au) | failed |",
+			},
+			wantStatus: []string{"failed"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routerCfg := testRouterConfig()
+			ref := gitRef{Tag: "2.4.0-RC1-SG-AU"}
+			var trace routeTrace
+			buildParams, err := generateBuildParams(&routerCfg, ref, &trace)
+			require.NoError(t, err, "generateBuildParams() err")
+			buildParams, err = routeWorkflows(buildParams, "release")
+			require.NoError(t, err, "routeWorkflows() err")
+			spec, err := parseTagSpec(&routerCfg, ref.token(), true)
+			require.NoError(t, err, "parseTagSpec() err")
+
+			report := startBuilds(buildParams[1:], 1, startFailurePolicyRollback, func(BuildParams) (bitrise.StartResponse, error) {
+				return bitrise.StartResponse{BuildSlug: "au", BuildNumber: 12}, nil
+			})
+			routed := newRouterSummary(newBuildPlan(ref, trace, buildParams), spec, report)
+			if tt.waited != nil {
+				children := report.children()
+				for _, build := range tt.waited {
+					children.update(build)
+				}
+				if tt.timedOut {
+					children.record(bitrise.WaitResult{TimedOut: true, Builds: []bitrise.BuildResult{{Slug: "au", Status: bitrise.BuildStatusRunning, TimedOut: true}}})
+				}
+				routed.setResults(children)
+			}
+
+			dir, err := ioutil.TempDir("", "router-summary")
+			require.NoError(t, err)
+			defer func() {
+				require.NoError(t, os.RemoveAll(dir))
+			}()
+			paths, err := routed.write(dir)
+			require.NoError(t, err, "routerSummary.write() err")
+			require.Len(t, paths, 2, "routerSummary.write() paths")
+
+			markdown, err := ioutil.ReadFile(paths[0])
+			require.NoError(t, err)
+			for _, line := range tt.wantMarkdown {
+				require.Contains(t, string(markdown), line, "routerSummary.markdown()")
+			}
+
+			b, err := ioutil.ReadFile(paths[1])
+			require.NoError(t, err)
+			var got routerSummary
+			require.NoError(t, json.Unmarshal(b, &got))
+			require.Equal(t, "2.4.0", got.Tag.Version, "routerSummary tag version")
+			require.Equal(t, planRoleParent, got.Entries[0].Role, "routerSummary parent entry")
+			require.Equal(t, tt.waited != nil, got.Waited, "routerSummary waited")
+			var status []string
+			for _, child := range got.Children {
+				status = append(status, child.Status)
+			}
+			require.Equal(t, tt.wantStatus, status, "routerSummary children status")
+		})
+	}
+}
+
+func Test_routerSummary_markdown_regions(t *testing.T) {
+	tests := []struct {
+		name string
+		ref  gitRef
+		want string
+	}{
+		{name: "listed regions", ref: gitRef{Tag: "2.4.0-RC1-SG-AU"}, want: "| Regions | SG, AU |"},
+		{name: "all marker", ref: gitRef{Tag: "2.4.0-RC3-ALL"}, want: "| Regions | ALL |"},
+		{name: "no region", ref: gitRef{Tag: "2.4.0-RC3"}, want: "| Regions | ALL |"},
+		{name: "excluded without all marker", ref: gitRef{Tag: "3.1.0-RC2-NOJP"}, want: "| Regions | ALL except JP |"},
+		{name: "excluded with all marker", ref: gitRef{Tag: "3.1.0-RC2-ALL-NOJP-NOAU"}, want: "| Regions | ALL except JP, AU |"},
+		{name: "branch", ref: gitRef{Branch: "feature/login"}, want: "| Regions | - |"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routerCfg := testRouterConfig()
+			spec, err := parseTagSpec(&routerCfg, tt.ref.token(), tt.ref.Tag != "")
+			require.NoError(t, err, "parseTagSpec() err")
+
+			summary := routerSummary{Ref: tt.ref, Tag: spec}
+			require.Contains(t, summary.markdown(), tt.want, "routerSummary.markdown()")
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// TagSpec is a tag or branch name parsed into its routing tokens
+type TagSpec struct {
+	Version   string    `json:"version,omitempty"` // as written in the tag, e.g. v2.3.1
+	SemVer    *Version  `json:"semver,omitempty"`
+	RC        string    `json:"rc,omitempty"`
+	Regions   []string  `json:"regions,omitempty"`  // region codes as configured, aliases resolved
+	Excludes  []string  `json:"excludes,omitempty"` // region codes dropped from the "ALL" build
+	All       bool      `json:"all"`
+	Vendors   []string  `json:"vendors,omitempty"`
+	APK       bool      `json:"apk"`
+	BuildType BuildType `json:"build_type,omitempty"` // selected by a build type marker
+	Remainder []string  `json:"remainder,omitempty"`  // free-text tokens, kept in generated tags
+}
+
+func (spec TagSpec) hasRegion(code string) bool {
+	for _, region := range spec.Regions {
+		if region == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (spec TagSpec) excludes(code string) bool {
+	for _, region := range spec.Excludes {
+		if region == code {
+			return true
+		}
+	}
+	return false
+}
+
+// prereleaseLikeExp matches tokens meant to be a prerelease, e.g. rc.1 or pre2, which have to match
+// the prerelease pattern when they follow the version or the tag is rejected
+var prereleaseLikeExp = regexp.MustCompile(`^[A-Za-z]+\.?\d`)
+
+// keywordExp matches tokens that look like routing keywords rather than free text
+var keywordExp = regexp.MustCompile(`^[A-Z0-9]*[A-Z][A-Z0-9]*$`)
+
+// TagSyntaxError is returned for tags with unknown or conflicting tokens
+type TagSyntaxError struct {
+	Tag    string
+	Token  string
+	Reason string
+}
+
+// Error implements builtin errors.Error.
+func (e *TagSyntaxError) Error() string {
+	return fmt.Sprintf("invalid tag %s: token %s: %s", e.Tag, e.Token, e.Reason)
+}
+
+func splitTagTokens(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == '-' || r == '/'
+	})
+}
+
+func fullMatch(pattern string) *regexp.Regexp {
+	return regexp.MustCompile(`^(?:` + pattern + `)$`)
+}
+
+// parseTagSpec tokenizes a tag or branch name.
+// In strict mode (tags) unknown keyword-like tokens are errors, otherwise (branches) they are kept as free text.
+func parseTagSpec(routerCfg *RouterConfig, s string, strict bool) (TagSpec, error) {
+	rcExp := fullMatch(routerCfg.Tag.RCPattern)
+	prereleaseExp := fullMatch(routerCfg.Tag.PrereleasePattern)
+	markerExps := make(map[string]*regexp.Regexp)
+	for name, buildType := range routerCfg.BuildTypes {
+		if buildType.Marker != "" {
+			markerExps[name] = fullMatch(buildType.Marker)
+		}
+	}
+	markerBuildType := func(token string) BuildType {
+		for _, name := range routerCfg.buildTypeNames() {
+			if exp, ok := markerExps[name]; ok && exp.MatchString(token) {
+				return BuildType(name)
+			}
+		}
+		return ""
+	}
+
+	var spec TagSpec
+	errorf := func(token, format string, a ...interface{}) error {
+		return &TagSyntaxError{Tag: s, Token: token, Reason: fmt.Sprintf(format, a...)}
+	}
+
+	versionIndex := -1
+	for i, token := range splitTagTokens(s) {
+		switch {
+		case versionLikeExp.MatchString(token):
+			version, err := parseVersion(token)
+			if err != nil {
+				if strict {
+					return TagSpec{}, errorf(token, "%s", err)
+				}
+				spec.Remainder = append(spec.Remainder, token)
+				continue
+			}
+			if spec.Version != "" {
+				return TagSpec{}, errorf(token, "version already set to %s", spec.Version)
+			}
+			spec.Version = token
+			spec.SemVer = &version
+			versionIndex = i
+		case markerBuildType(token) != "":
+			// markers come first, so a registered build type is selected even if the prerelease pattern is broad
+			buildType := markerBuildType(token)
+			if spec.BuildType != "" {
+				return TagSpec{}, errorf(token, "conflicts with %s build", spec.BuildType)
+			}
+			spec.BuildType = buildType
+		case rcExp.MatchString(token):
+			if spec.RC != "" {
+				return TagSpec{}, errorf(token, "RC already set to %s", spec.RC)
+			}
+			spec.RC = token
+		case prereleaseExp.MatchString(strings.SplitN(token, "+", 2)[0]):
+			if spec.SemVer == nil {
+				if strict {
+					return TagSpec{}, errorf(token, "prerelease must follow the version")
+				}
+				spec.Remainder = append(spec.Remainder, token)
+				continue
+			}
+			if spec.SemVer.Prerelease != "" {
+				return TagSpec{}, errorf(token, "prerelease already set to %s", spec.SemVer.Prerelease)
+			}
+			// semver puts the metadata after the prerelease, e.g. 2.3.1-beta.2+45
+			prerelease := strings.SplitN(token, "+", 2)
+			if len(prerelease) == 2 {
+				if spec.SemVer.Metadata != "" {
+					return TagSpec{}, errorf(token, "metadata already set to %s", spec.SemVer.Metadata)
+				}
+				spec.SemVer.Metadata = prerelease[1]
+			}
+			spec.SemVer.Prerelease = prerelease[0]
+		case strings.EqualFold(token, routerCfg.Tag.AllMarker):
+			spec.All = true
+		case strings.EqualFold(token, routerCfg.Tag.APKMarker):
+			spec.APK = true
+		case routerCfg.vendor(token) != "":
+			vendor := routerCfg.vendor(token)
+			for _, v := range spec.Vendors {
+				if v == vendor {
+					return TagSpec{}, errorf(token, "vendor %s listed more than once", vendor)
+				}
+			}
+			spec.Vendors = append(spec.Vendors, vendor)
+		default:
+			if region, ok := routerCfg.regionForToken(token); ok {
+				if spec.hasRegion(region.Code) {
+					return TagSpec{}, errorf(token, "region %s listed more than once", region.Code)
+				}
+				spec.Regions = append(spec.Regions, region.Code)
+				continue
+			}
+			if prefix := routerCfg.Tag.ExcludePrefix; len(token) > len(prefix) && strings.EqualFold(token[:len(prefix)], prefix) {
+				if region, ok := routerCfg.regionForToken(token[len(prefix):]); ok {
+					if spec.excludes(region.Code) {
+						return TagSpec{}, errorf(token, "region %s excluded more than once", region.Code)
+					}
+					spec.Excludes = append(spec.Excludes, region.Code)
+					continue
+				}
+				if strict && keywordExp.MatchString(token) {
+					return TagSpec{}, errorf(token, "unknown region %s to exclude", token[len(prefix):])
+				}
+			}
+			if strict && versionIndex >= 0 && i == versionIndex+1 && prereleaseLikeExp.MatchString(token) {
+				// anything unknown would turn a prerelease into a release build
+				return TagSpec{}, errorf(token, "unknown prerelease, expected one matching %s", routerCfg.Tag.PrereleasePattern)
+			}
+			if strict && keywordExp.MatchString(token) {
+				for _, region := range routerCfg.Regions {
+					if strings.HasPrefix(token, strings.ToUpper(region.Code)) {
+						return TagSpec{}, errorf(token, "unknown token, region %s must be a separate token", region.Code)
+					}
+				}
+				return TagSpec{}, errorf(token, "unknown token")
+			}
+			spec.Remainder = append(spec.Remainder, token)
+		}
+	}
+
+	if spec.All && len(spec.Regions) > 0 {
+		return TagSpec{}, errorf(routerCfg.Tag.AllMarker, "conflicts with region %s", spec.Regions[0])
+	}
+	if len(spec.Excludes) > 0 && len(spec.Regions) > 0 {
+		return TagSpec{}, errorf(routerCfg.Tag.ExcludePrefix+spec.Excludes[0], "excluding regions conflicts with region %s", spec.Regions[0])
+	}
+	return spec, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseTagSpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		tag     string
+		strict  bool
+		want    TagSpec
+		wantErr string
+	}{
+		{
+			name:   "qa tag",
+			tag:    "2.4.0-RC3-AU",
+			strict: true,
+			want:   TagSpec{Version: "2.4.0", SemVer: &Version{Major: 2, Minor: 4, Patch: 0}, RC: "RC3", Regions: []string{"AU"}},
+		},
+		{
+			name:   "release tag with vendor and apk",
+			tag:    "2.4.0-sg-HMS-APK",
+			strict: true,
+			want:   TagSpec{Version: "2.4.0", SemVer: &Version{Major: 2, Minor: 4, Patch: 0}, Regions: []string{"SG"}, Vendors: []string{"HMS"}, APK: true},
+		},
+		{
+			name:   "all with free text",
+			tag:    "2.4.0-hotfix-ALL-RC1",
+			strict: true,
+			want:   TagSpec{Version: "2.4.0", SemVer: &Version{Major: 2, Minor: 4, Patch: 0}, RC: "RC1", All: true, Remainder: []string{"hotfix"}},
+		},
+		{
+			name:   "multiple regions",
+			tag:    "2.4.0-RC3-au-SG-JP",
+			strict: true,
+			want:   TagSpec{Version: "2.4.0", SemVer: &Version{Major: 2, Minor: 4, Patch: 0}, RC: "RC3", Regions: []string{"AU", "SG", "JP"}},
+		},
+		{
+			name:    "duplicate region",
+			tag:     "2.4.0-AU-au",
+			strict:  true,
+			wantErr: "invalid tag 2.4.0-AU-au: token au: region AU listed more than once",
+		},
+		{
+			name:   "all except",
+			tag:    "3.1.0-RC2-ALL-NOJP-noau",
+			strict: true,
+			want:   TagSpec{Version: "3.1.0", SemVer: &Version{Major: 3, Minor: 1, Patch: 0}, RC: "RC2", All: true, Excludes: []string{"JP", "AU"}},
+		},
+		{
+			name:    "exclude unknown region",
+			tag:     "3.1.0-RC2-NOXX",
+			strict:  true,
+			wantErr: "invalid tag 3.1.0-RC2-NOXX: token NOXX: unknown region XX to exclude",
+		},
+		{
+			name:    "exclude with explicit region",
+			tag:     "3.1.0-AU-NOJP",
+			strict:  true,
+			wantErr: "invalid tag 3.1.0-AU-NOJP: token NOJP: excluding regions conflicts with region AU",
+		},
+		{
+			name:   "semver with prefix, prerelease and metadata",
+			tag:    "v2.3.1-beta.2+45-AU",
+			strict: true,
+			want:   TagSpec{Version: "v2.3.1", SemVer: &Version{Major: 2, Minor: 3, Patch: 1, Prerelease: "beta.2", Metadata: "45"}, Regions: []string{"AU"}},
+		},
+		{
+			name:   "rc prerelease",
+			tag:    "2.3.1-rc.1-AU",
+			strict: true,
+			want:   TagSpec{Version: "2.3.1", SemVer: &Version{Major: 2, Minor: 3, Patch: 1, Prerelease: "rc.1"}, Regions: []string{"AU"}},
+		},
+		{
+			name:    "unknown prerelease",
+			tag:     "2.3.1-pre2-AU",
+			strict:  true,
+			wantErr: "invalid tag 2.3.1-pre2-AU: token pre2: unknown prerelease, expected one matching (?i)(alpha|beta|dev|preview|rc)(\\.?\\d+)*",
+		},
+		{
+			name:   "four component version",
+			tag:    "2.3.1.4-RC1",
+			strict: true,
+			want:   TagSpec{Version: "2.3.1.4", SemVer: &Version{Major: 2, Minor: 3, Patch: 1, Revision: 4, HasRevision: true}, RC: "RC1"},
+		},
+		{
+			name:    "malformed version",
+			tag:     "2.3-RC1",
+			strict:  true,
+			wantErr: "invalid tag 2.3-RC1: token 2.3: malformed version 2.3, expected MAJOR.MINOR.PATCH[.REVISION][+METADATA]",
+		},
+		{
+			name:    "prerelease without version",
+			tag:     "beta.2-AU",
+			strict:  true,
+			wantErr: "invalid tag beta.2-AU: token beta.2: prerelease must follow the version",
+		},
+		{
+			name:    "region prefix",
+			tag:     "1.2.3-AUTO-RC1",
+			strict:  true,
+			wantErr: "invalid tag 1.2.3-AUTO-RC1: token AUTO: unknown token, region AU must be a separate token",
+		},
+		{
+			name:    "unknown keyword",
+			tag:     "1.2.3-XYZ",
+			strict:  true,
+			wantErr: "invalid tag 1.2.3-XYZ: token XYZ: unknown token",
+		},
+		{
+			name:   "multiple vendors",
+			tag:    "1.2.3-GMS-hms",
+			strict: true,
+			want:   TagSpec{Version: "1.2.3", SemVer: &Version{Major: 1, Minor: 2, Patch: 3}, Vendors: []string{"GMS", "HMS"}},
+		},
+		{
+			name:    "duplicate vendor",
+			tag:     "1.2.3-HMS-hms",
+			strict:  true,
+			wantErr: "invalid tag 1.2.3-HMS-hms: token hms: vendor HMS listed more than once",
+		},
+		{
+			name:    "two rcs",
+			tag:     "1.2.3-RC1-RC2",
+			strict:  true,
+			wantErr: "invalid tag 1.2.3-RC1-RC2: token RC2: RC already set to RC1",
+		},
+		{
+			name:    "all with region",
+			tag:     "1.2.3-ALL-AU",
+			strict:  true,
+			wantErr: "invalid tag 1.2.3-ALL-AU: token ALL: conflicts with region AU",
+		},
+		{
+			name: "lenient branch",
+			tag:  "feature/AU-JIRA-123",
+			want: TagSpec{Regions: []string{"AU"}, Remainder: []string{"feature", "JIRA", "123"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routerCfg := testRouterConfig()
+			got, err := parseTagSpec(&routerCfg, tt.tag, tt.strict)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr, "parseTagSpec()")
+				return
+			}
+			require.NoError(t, err, "parseTagSpec() err")
+			require.Equal(t, tt.want, got, "parseTagSpec()")
+		})
+	}
+}
@@ -8,43 +8,45 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"text/template"
 
 	"github.com/bitrise-io/go-utils/log"
 )
 
-type BuildType int
+// BuildType is the name of a build type registered in RouterConfig.BuildTypes
+type BuildType string
 
+// build types every router config has, used for branches, tags and versions
 const (
-	Debug BuildType = iota
-	Qa
-	Release
+	Debug   BuildType = "debug"
+	Qa      BuildType = "qa"
+	Release BuildType = "release"
 )
 
 func (bt BuildType) Name() string {
-	switch bt {
-	case Debug:
-		return "debug"
-	case Qa:
-		return "qa"
-	case Release:
-		return "release"
-	default:
-		return ""
-	}
+	return string(bt)
 }
 
 type BuildParams struct {
-	GradleBuildTask    string    `env:"GRADLE_BUILD" json:"build_task"`
-	GradleTestTask     string    `env:"GRADLE_TEST" json:"test_task"`
-	Alpha2Code         string    `env:"ALPHA_2_CODE" json:"-"`        // Slack, Browserstack
-	SlackFlag          string    `env:"SLACK_FLAG" json:"-"`          // Slack
-	BuildRegion        string    `env:"SLACK_REGION" json:"-"`        // Slack
-	GServicesXMLPath   string    `env:"GMS_XML" json:"-"`             // QA
-	PackageName        string    `env:"PKG_NAME" json:"pkg"`          // Prod
-	BrowserstackSuffix string    `env:"BS_SUFFIX" json:"bs_suffix"`   // Browserstack
-	NewTag             string    `env:"-" json:"new_tag"`             // Internal
-	NewCommitHash      string    `env:"-" json:"new_commit_hash"`     // Internal
-	TgtBuildType       BuildType `env:"BUILD_TYPE" json:"build_type"` // Internal
+	GradleBuildTask    string `env:"GRADLE_BUILD" json:"build_task"`
+	GradleTestTask     string `env:"GRADLE_TEST" json:"test_task"`
+	Alpha2Code         string `env:"ALPHA_2_CODE" json:"-"`      // Slack, Browserstack
+	SlackFlag          string `env:"SLACK_FLAG" json:"-"`        // Slack
+	BuildRegion        string `env:"SLACK_REGION" json:"-"`      // Slack
+	GServicesXMLPath   string `env:"GMS_XML" json:"-"`           // QA
+	PackageName        string `env:"PKG_NAME" json:"pkg"`        // Prod
+	BrowserstackSuffix string `env:"BS_SUFFIX" json:"bs_suffix"` // Browserstack
+	VendorService      string `env:"VENDOR_SVC" json:"vendor"`   // Gradle, e.g. GMS or HMS
+	VersionName        string `env:"VERSION_NAME" json:"version_name"`
+	VersionMajor       string `env:"VERSION_MAJOR" json:"version_major"`
+	VersionMinor       string `env:"VERSION_MINOR" json:"version_minor"`
+	VersionPatch       string `env:"VERSION_PATCH" json:"version_patch"`
+	VersionPrerelease  string `env:"VERSION_PRERELEASE" json:"version_prerelease"`
+	NewTag             string `env:"-" json:"new_tag"`             // Internal
+	NewCommitHash      string `env:"-" json:"new_commit_hash"`     // Internal
+	TgtBuildType       int    `env:"BUILD_TYPE" json:"build_type"` // Internal, id of the build type
+	BuildTypeName      string `env:"BUILD_TYPE_NAME" json:"build_type_name"`
+	Workflow           string `env:"-" json:"workflow"` // Internal, empty for the triggered workflow
 }
 
 const NONE = "none"
@@ -53,14 +55,6 @@ const GSERVICES_XML_FILE_PATH = "accmng/build/generated/res/google-services/%s/%
 // Check the link below if the build fails
 // https://developers.google.com/android/guides/google-services-plugin#processing_the_json_file
 
-func reverseMap(m *map[string]string) map[string]string {
-	tmpMap := make(map[string]string)
-	for k, v := range *m {
-		tmpMap[v] = k
-	}
-	return tmpMap
-}
-
 func toBool(envvar string) bool {
 	if pr, ok := os.LookupEnv(envvar); ok {
 		if b, _ := strconv.ParseBool(pr); b {
@@ -82,22 +76,113 @@ func snakify(words ...string) string {
 	return out
 }
 
-func findStringOrDefault(re *regexp.Regexp, str string, def string) string {
-	if match := re.FindString(str); match != "" {
-		return match
+func stringOrDefault(str string, def string) string {
+	if str != "" {
+		return str
 	}
 	return def
 }
 
-func generatePackageName(region string, a2code string, buildType *BuildType) string {
-	basePkg := "com.circles.selfcare"
-	if region != "singapore" {
-		basePkg = basePkg + "." + strings.ToLower(a2code)
+// defaultPackageTemplate reproduces the package names used before they became templates
+const defaultPackageTemplate = `{{.Base}}{{if not .BasePackage}}.{{lower .A2}}{{end}}{{.VendorSuffix}}{{.BuildTypeSuffix}}`
+
+var packageNameExp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*(\.[a-zA-Z][a-zA-Z0-9_]*)+$`)
+
+// default task templates reproduce the task names used before they became templates
+var (
+	defaultBuildTaskTemplates = []string{`{{camel .Command .Region (lower .Vendor) .BuildType}}`}
+	defaultTestTaskTemplates  = []string{`{{camel "test" .Region (lower .Vendor) .BuildType "unit" "test"}}`}
+)
+
+var templateFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"camel": snakify,
+}
+
+// packageNameData is what package name templates are executed with
+type packageNameData struct {
+	Base            string // naming.package_base
+	A2              string // alias or code of the region
+	Code            string
+	Region          string
+	BuildType       string
+	Vendor          string
+	BasePackage     bool
+	VendorSuffix    string // naming.vendor_package_suffixes
+	BuildTypeSuffix string // build_types.*.package_suffix
+}
+
+func generatePackageName(routerCfg *RouterConfig, region RegionConfig, a2code string, vendor string, buildType *BuildType) (string, error) {
+	pkgTemplate := routerCfg.Naming.PackageTemplate
+	if region.PackageTemplate != "" {
+		pkgTemplate = region.PackageTemplate
+	}
+	tmpl, err := template.New("package").Funcs(templateFuncs).Parse(pkgTemplate)
+	if err != nil {
+		return "", fmt.Errorf("invalid package template of region %s: %s", region.Code, err)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, packageNameData{
+		Base:            routerCfg.Naming.PackageBase,
+		A2:              a2code,
+		Code:            region.Code,
+		Region:          region.Name,
+		BuildType:       buildType.Name(),
+		Vendor:          vendor,
+		BasePackage:     region.BasePackage,
+		VendorSuffix:    routerCfg.Naming.VendorPackageSuffixes[vendor],
+		BuildTypeSuffix: routerCfg.buildType(*buildType).PackageSuffix,
+	}); err != nil {
+		return "", fmt.Errorf("failed to generate package name of region %s: %s", region.Code, err)
 	}
-	if *buildType != Release {
-		basePkg = basePkg + "." + buildType.Name()
+
+	pkg := b.String()
+	if !packageNameExp.MatchString(pkg) {
+		return "", fmt.Errorf("invalid package name %q generated for region %s", pkg, region.Code)
 	}
-	return basePkg
+	return pkg, nil
+}
+
+// gradleTaskData is what gradle task templates are executed with
+type gradleTaskData struct {
+	Command   string // assemble or bundle
+	Region    string
+	Code      string
+	A2        string // alias or code of the region
+	Vendor    string
+	BuildType string
+}
+
+// generateGradleTasks executes every template, returning the tasks separated by spaces
+func generateGradleTasks(templates []string, data gradleTaskData) (string, error) {
+	var tasks []string
+	for _, taskTemplate := range templates {
+		tmpl, err := template.New("task").Funcs(templateFuncs).Parse(taskTemplate)
+		if err != nil {
+			return "", fmt.Errorf("invalid task template %q: %s", taskTemplate, err)
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			return "", fmt.Errorf("failed to generate task of region %s: %s", data.Code, err)
+		}
+		task := strings.TrimSpace(b.String())
+		if task == "" || strings.ContainsAny(task, " \t\n") {
+			return "", fmt.Errorf("invalid task %q generated by %q for region %s", task, taskTemplate, data.Code)
+		}
+		tasks = append(tasks, task)
+	}
+	return strings.Join(tasks, " "), nil
+}
+
+func generateServicesConfigPath(routerCfg *RouterConfig, region RegionConfig, vendor string, buildType BuildType) string {
+	pathFmt := routerCfg.Naming.GServicesXMLPath
+	if vendorFmt, ok := routerCfg.Naming.ServicesConfigPaths[vendor]; ok {
+		pathFmt = vendorFmt
+	}
+	flavor := snakify(region.Name, strings.ToLower(vendor))
+	return fmt.Sprintf(pathFmt, flavor, routerCfg.buildType(buildType).GradleName)
 }
 
 func joinIgnoreEmpty(items []string, sep string) string {
@@ -135,127 +220,309 @@ func removeKeywords(lut []string, original string, sep string) string {
 	return newTagBuilder.String()
 }
 
-func generateNewTag(currentTag string, version string, a2 string, rc string, buildType BuildType) string {
-	lut := []string{currentTag, version, a2, rc, "ALL", "APK"}
+// generateNewTag builds the tag of a single region. A non-empty vendor replaces the vendors of the
+// current tag, so the builds of a vendor fan-out do not share their tag.
+func generateNewTag(routerCfg *RouterConfig, currentTag string, spec TagSpec, a2 string, vendor string, buildType BuildType) string {
+	lut := []string{currentTag, spec.Version, a2, spec.RC, routerCfg.Tag.AllMarker, routerCfg.Tag.APKMarker}
+	// drop every region of the tag, including the aliases they were listed by
+	for _, code := range spec.Regions {
+		if region, ok := routerCfg.region(code); ok {
+			lut = append(lut, region.Code, region.Alias)
+		}
+	}
+	for _, code := range spec.Excludes {
+		if region, ok := routerCfg.region(code); ok {
+			lut = append(lut, routerCfg.Tag.ExcludePrefix+region.Code)
+			if region.Alias != "" {
+				lut = append(lut, routerCfg.Tag.ExcludePrefix+region.Alias)
+			}
+		}
+	}
+	if vendor != "" {
+		lut = append(lut, spec.Vendors...)
+	}
 	newTag := removeKeywords(lut, currentTag, "-")
-	switch buildType {
-	case Qa:
-		newTag = joinIgnoreEmpty([]string{version, newTag, a2, rc}, "-")
+	switch {
+	case routerCfg.buildType(buildType).Release:
+		newTag = joinIgnoreEmpty([]string{spec.Version, a2, vendor}, "-")
 		break
-	case Release:
-		newTag = fmt.Sprintf("%s-%s", version, a2)
+	case buildType == Debug:
+		newTag = joinIgnoreEmpty([]string{newTag, vendor}, "-")
 		break
+	default:
+		newTag = joinIgnoreEmpty([]string{spec.Version, newTag, vendor, a2, spec.RC}, "-")
 	}
 	return newTag
 }
 
-func generateBuildParams(supportedRegions map[string]string, allTagExcludes map[string]bool, supportedRegionAlias map[string]string, defaultRegion string) []BuildParams {
-	var token string
+// gitRef is the git reference a routing decision is made on
+type gitRef struct {
+	Tag    string `json:"tag,omitempty"`
+	Branch string `json:"branch,omitempty"`
+	IsPR   bool   `json:"is_pr"`
+}
+
+// token returns the name routed by the router, the tag or else the branch without its prefix,
+// e.g. feature/AU-login routes as AU-login
+func (ref gitRef) token() string {
+	if ref.Tag != "" {
+		return ref.Tag
+	}
+	if i := strings.Index(ref.Branch, "/"); i >= 0 {
+		return ref.Branch[i+1:]
+	}
+	return ref.Branch
+}
+
+func gitRefFromEnv() gitRef {
+	return gitRef{
+		Tag:    os.Getenv("BITRISE_GIT_TAG"),
+		Branch: os.Getenv("BITRISE_GIT_BRANCH"),
+		IsPR:   toBool("PR"),
+	}
+}
+
+// routeTrace records why generateBuildParams made its decisions
+type routeTrace []string
 
+func (t *routeTrace) add(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	log.Debugf(msg)
+	*t = append(*t, msg)
+}
+
+func generateBuildParams(routerCfg *RouterConfig, ref gitRef, trace *routeTrace) ([]BuildParams, error) {
 	buildType := Debug
+	var rule BranchRule
+	hasRule := false
 
-	if tag, ok := os.LookupEnv("BITRISE_GIT_TAG"); ok {
-		token = tag
+	token := ref.token()
+	if ref.Tag != "" {
 		buildType = Qa
-	} else if branch, ok := os.LookupEnv("BITRISE_GIT_BRANCH"); ok {
-		if strings.Contains(branch, "/") {
-			token = branch[strings.Index(tag, "/")+1:]
+		trace.add("tag %s found, defaulting to %s build", ref.Tag, buildType.Name())
+	} else if branch := ref.Branch; branch != "" {
+		rule, hasRule = routerCfg.branchRule(branch)
+		if hasRule {
+			trace.add("branch %s matches rule %s", branch, rule.Pattern)
 		} else {
-			token = branch
+			trace.add("no tag, routing branch %s as %s build", branch, buildType.Name())
 		}
 	} else {
-		os.Exit(1)
+		return nil, fmt.Errorf("neither BITRISE_GIT_TAG nor BITRISE_GIT_BRANCH is set")
 	}
 
-	a2codes := make([]string, len(supportedRegions))
-	i := 0
-	for key := range supportedRegions {
-		a2codes[i] = key
-		i++
+	spec, err := parseTagSpec(routerCfg, token, ref.Tag != "")
+	if err != nil {
+		return nil, err
 	}
 
-	versionExp := regexp.MustCompile(`\d+\.\d+\.\d+`)
-	rcExp := regexp.MustCompile(`RC\d+`)
-	regionExp := regexp.MustCompile(`(?i)` + strings.Join(a2codes, "|"))
-	vendorSvcExp := regexp.MustCompile(`(G|H)MS`)
-
-	version := findStringOrDefault(versionExp, token, NONE)
-	rc := findStringOrDefault(rcExp, token, NONE)
-	regionA2 := findStringOrDefault(regionExp, token, NONE)
-	vendorSvc := findStringOrDefault(vendorSvcExp, token, NONE)
-	isApk := strings.Contains(token, "-APK")
+	version := stringOrDefault(spec.Version, NONE)
+	rc := stringOrDefault(spec.RC, NONE)
+	regionA2 := stringOrDefault(strings.Join(spec.Regions, ","), NONE)
+	vendorSvc := stringOrDefault(strings.Join(spec.Vendors, ","), NONE)
+	isApk := spec.APK
 
 	envLogFmt := "Environment information:\nversion=\"%s\"\nrc=\"%s\"\nregionA2=\"%s\"\nvendorSvc=\"%s\""
 	//println(fmt.Sprintf(envLogFmt, version, rc, regionA2, vendorSvc))
 	log.Infof(fmt.Sprintf(envLogFmt, version, rc, regionA2, vendorSvc))
 
-	if vendorSvc == NONE {
-		vendorSvc = "GMS"
+	vendors := spec.Vendors
+	if len(vendors) == 0 && len(rule.Vendors) > 0 {
+		vendors = rule.Vendors
+		trace.add("no vendor in %s, using vendors %s of rule %s", token, strings.Join(vendors, ", "), rule.Pattern)
+	} else if len(vendors) == 0 {
+		vendors = routerCfg.Vendors.Default
+		trace.add("no vendor in %s, using default vendors %s", token, strings.Join(vendors, ", "))
+	} else {
+		trace.add("vendors %s parsed from %s", strings.Join(vendors, ", "), token)
+	}
+
+	var versionName, versionMajor, versionMinor, versionPatch, versionPrerelease string
+	if semver := spec.SemVer; semver != nil {
+		versionName = semver.Name()
+		versionMajor = strconv.Itoa(semver.Major)
+		versionMinor = strconv.Itoa(semver.Minor)
+		versionPatch = strconv.Itoa(semver.Patch)
+		versionPrerelease = semver.Prerelease
+		trace.add("version %s parsed from %s", versionName, version)
+
+		if rc == NONE && semver.Prerelease == "" && spec.BuildType == "" {
+			buildType = Release
+			trace.add("version %s without RC, switching to %s build", version, buildType.Name())
+		} else if rc == NONE && semver.Prerelease != "" {
+			trace.add("prerelease %s without RC, keeping %s build", semver.Prerelease, buildType.Name())
+		}
 	}
+	if spec.BuildType != "" {
+		buildType = spec.BuildType
+		trace.add("%s build selected by its marker in %s", buildType.Name(), token)
+	} else if rule.BuildType != "" {
+		buildType = BuildType(rule.BuildType)
+		trace.add("%s build selected by rule %s", buildType.Name(), rule.Pattern)
+	}
+	buildTypeCfg := routerCfg.buildType(buildType)
 
-	if version != NONE && rc == NONE {
-		buildType = Release
+	buildCmds := make(map[string]string)
+	for _, vendor := range routerCfg.Vendors.Supported {
+		buildCmds[vendor] = "assemble"
+		if !isApk && buildTypeCfg.Release && routerCfg.isBundleVendor(vendor) {
+			buildCmds[vendor] = "bundle"
+		}
+	}
+	if !isApk && buildTypeCfg.Release {
+		trace.add("%s build without %s marker, building bundles for %s", buildType.Name(), routerCfg.Tag.APKMarker, strings.Join(routerCfg.Vendors.Bundle, ", "))
 	}
 
-	buildCmd := "assemble"
-	if !isApk && buildType == Release && vendorSvc == "GMS" {
-		buildCmd = "bundle"
+	regionCodes := spec.Regions
+	if len(regionCodes) == 0 && len(rule.Regions) > 0 {
+		regionCodes = rule.Regions
+		trace.add("no region in %s, using regions %s of rule %s", token, strings.Join(regionCodes, ", "), rule.Pattern)
 	}
 
-	var buildRegions []string
-	var newTagMapping = make(map[string]string)
-	mapping, exists := supportedRegions[strings.ToUpper(regionA2)]
-	if exists {
+	var buildRegions []RegionConfig
+	// builds of a multi region run get their own tag
+	retag := false
+	if len(regionCodes) == 1 {
 		// single build
-		buildRegions = append(buildRegions, mapping)
-	} else if toBool("PR") {
+		region, _ := routerCfg.region(regionCodes[0])
+		trace.add("region %s parsed from %s", region.Code, token)
+		buildRegions = append(buildRegions, region)
+	} else if len(regionCodes) > 1 {
+		// explicit subset of regions, tagged like the "ALL" build
+		trace.add("regions %s parsed from %s", strings.Join(regionCodes, ", "), token)
+		for _, code := range regionCodes {
+			region, _ := routerCfg.region(code)
+			buildRegions = append(buildRegions, region)
+		}
+		retag = true
+	} else if ref.IsPR && !rule.AllRegions {
 		// fallback to default region builds on PRs
-		buildRegions = append(buildRegions, supportedRegions[defaultRegion])
+		trace.add("PR fallback to default region %s", routerCfg.DefaultRegion)
+		defaultRegion, _ := routerCfg.region(routerCfg.DefaultRegion)
+		buildRegions = append(buildRegions, defaultRegion)
 	} else {
 		// "ALL" build, iterate supported regions
-		for a2, region := range supportedRegions {
+		trace.add("no region in %s, building all regions", token)
+		for _, region := range routerCfg.Regions {
 			// remember to exclude it tho
-			if !allTagExcludes[a2] {
-				newTagMapping[region] = generateNewTag(token, version, a2, rc, buildType)
+			if routerCfg.isExcludedFromAll(region.Code) {
+				trace.add("region %s excluded by all_excludes", region.Code)
+			} else if spec.excludes(region.Code) {
+				trace.add("region %s excluded by %s%s in %s", region.Code, routerCfg.Tag.ExcludePrefix, region.Code, token)
+			} else {
 				buildRegions = append(buildRegions, region)
 			}
 		}
+		if len(buildRegions) == 0 {
+			return nil, fmt.Errorf("every region of %s is excluded, nothing to build", token)
+		}
+		retag = true
+	}
+
+	// unsupported combinations fail regions listed in the tag, others are skipped
+	explicitRegions := len(spec.Regions) > 0
+	skipf := func(format string, a ...interface{}) error {
+		msg := fmt.Sprintf(format, a...)
+		if explicitRegions {
+			return fmt.Errorf("%s, requested by %s", msg, token)
+		}
+		log.Warnf("Skipping: %s", msg)
+		trace.add("skipped: %s", msg)
+		return nil
 	}
 
 	var buildParams []BuildParams
-	var regionToA2 = reverseMap(&supportedRegions)
 	for _, buildRegion := range buildRegions {
-		flavor := snakify(buildRegion, "gms")
-		a2Code := regionToA2[buildRegion]
-		bsSuffix := "QA"
-
-		a2codeAlias, aliasExists := supportedRegionAlias[a2Code]
-		if aliasExists {
-			a2Code = a2codeAlias
+		if !buildRegion.supportsBuildType(buildType) {
+			if err := skipf("region %s does not ship %s builds", buildRegion.Code, buildType.Name()); err != nil {
+				return nil, err
+			}
+			continue
 		}
 
-		if buildType == Release {
-			bsSuffix = "PROD"
+		var regionVendors []string
+		for _, vendor := range vendors {
+			if buildRegion.supportsVendor(vendor) {
+				regionVendors = append(regionVendors, vendor)
+			} else if len(spec.Vendors) > 0 {
+				if err := skipf("region %s does not ship %s", buildRegion.Code, vendor); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if len(regionVendors) == 0 && len(spec.Vendors) == 0 {
+			// no vendor requested and the defaults do not apply, build what the region ships
+			regionVendors = buildRegion.Vendors
+			trace.add("region %s only ships %s", buildRegion.Code, strings.Join(regionVendors, ", "))
 		}
 
-		buildParam := BuildParams{
-			GradleBuildTask:    snakify(buildCmd, buildRegion, strings.ToLower(vendorSvc), buildType.Name()),
-			GradleTestTask:     snakify("test", buildRegion, strings.ToLower(vendorSvc), buildType.Name(), "unit", "test"),
-			Alpha2Code:         a2Code,
-			SlackFlag:          fmt.Sprintf(":flag-%s:", strings.ToLower(a2Code)),
-			BuildRegion:        strings.Title(buildRegion),
-			GServicesXMLPath:   fmt.Sprintf(GSERVICES_XML_FILE_PATH, flavor, buildType.Name()),
-			PackageName:        generatePackageName(buildRegion, a2Code, &buildType),
-			BrowserstackSuffix: bsSuffix,
-			NewTag:             newTagMapping[buildRegion],
-			NewCommitHash:      revParseTag(os.Getenv("BITRISE_GIT_TAG")),
-			TgtBuildType:       buildType,
+		a2Code := buildRegion.Code
+		if buildRegion.Alias != "" {
+			a2Code = buildRegion.Alias
 		}
 
-		buildParams = append(buildParams, buildParam)
+		for _, vendor := range regionVendors {
+			newTag := ""
+			switch {
+			case ref.Tag == "":
+				// branch builds keep the branch, a generated tag would make them look like tag builds
+			case len(vendors) > 1:
+				newTag = generateNewTag(routerCfg, token, spec, buildRegion.Code, vendor, buildType)
+			case retag:
+				newTag = generateNewTag(routerCfg, token, spec, buildRegion.Code, "", buildType)
+			}
+
+			pkg, err := generatePackageName(routerCfg, buildRegion, a2Code, vendor, &buildType)
+			if err != nil {
+				return nil, err
+			}
+			taskData := gradleTaskData{
+				Command:   buildCmds[vendor],
+				Region:    buildRegion.Name,
+				Code:      buildRegion.Code,
+				A2:        a2Code,
+				Vendor:    vendor,
+				BuildType: buildTypeCfg.GradleName,
+			}
+			buildTask, err := generateGradleTasks(routerCfg.Naming.BuildTaskTemplates, taskData)
+			if err != nil {
+				return nil, err
+			}
+			testTask, err := generateGradleTasks(routerCfg.Naming.TestTaskTemplates, taskData)
+			if err != nil {
+				return nil, err
+			}
+
+			buildParam := BuildParams{
+				GradleBuildTask:    buildTask,
+				GradleTestTask:     testTask,
+				Alpha2Code:         a2Code,
+				SlackFlag:          fmt.Sprintf(":flag-%s:", strings.ToLower(a2Code)),
+				BuildRegion:        strings.Title(buildRegion.Name),
+				GServicesXMLPath:   generateServicesConfigPath(routerCfg, buildRegion, vendor, buildType),
+				PackageName:        pkg,
+				BrowserstackSuffix: buildTypeCfg.BrowserstackSuffix,
+				VendorService:      vendor,
+				VersionName:        versionName,
+				VersionMajor:       versionMajor,
+				VersionMinor:       versionMinor,
+				VersionPatch:       versionPatch,
+				VersionPrerelease:  versionPrerelease,
+				NewTag:             newTag,
+				NewCommitHash:      revParseTag(ref.Tag),
+				TgtBuildType:       buildTypeCfg.ID,
+				BuildTypeName:      buildType.Name(),
+				Workflow:           routerCfg.workflow(buildRegion.Code, vendor, buildType),
+			}
+
+			buildParams = append(buildParams, buildParam)
+		}
 	}
 
-	return buildParams
+	if len(buildParams) == 0 {
+		return nil, fmt.Errorf("no region of %s ships a %s build of %s, nothing to build", token, buildType.Name(), strings.Join(vendors, ", "))
+	}
+	return buildParams, nil
 }
 
 func revParseTag(tag string) string {
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testRouterConfig() RouterConfig {
+	rc := defaultRouterConfig()
+	rc.DefaultRegion = "SG"
+	rc.Regions = []RegionConfig{
+		{Code: "SG", Name: "singapore", BasePackage: true},
+		{Code: "AU", Name: "australia", Alias: "au"},
+		{Code: "JP", Name: "japan"},
+	}
+	rc.AllExcludes = []string{"JP"}
+	rc.Branches = []BranchRule{
+		{Pattern: "release/*", BuildType: "qa", AllRegions: true},
+		{Pattern: "hotfix/*", BuildType: "qa", Regions: []string{"SG"}},
+	}
+	return rc
+}
+
+func Test_generateBuildParams(t *testing.T) {
+	// skip git rev-parse
+	require.NoError(t, os.Setenv("BITRISE_GIT_COMMIT", "abcdef"))
+	defer func() {
+		require.NoError(t, os.Unsetenv("BITRISE_GIT_COMMIT"))
+	}()
+
+	tests := []struct {
+		name      string
+		ref       gitRef
+		wantTasks []string
+		wantTags  []string
+		wantTrace string
+		wantErr   bool
+	}{
+		{
+			name:      "single region release",
+			ref:       gitRef{Tag: "2.4.0-AU"},
+			wantTasks: []string{"bundleAustraliaGmsRelease"},
+			wantTags:  []string{""},
+			wantTrace: "region AU parsed from 2.4.0-AU",
+		},
+		{
+			name:      "all regions qa",
+			ref:       gitRef{Tag: "2.4.0-RC3"},
+			wantTasks: []string{"assembleSingaporeGmsQa", "assembleAustraliaGmsQa"},
+			wantTags:  []string{"2.4.0-SG-RC3", "2.4.0-AU-RC3"},
+			wantTrace: "region JP excluded by all_excludes",
+		},
+		{
+			name:      "multiple regions qa",
+			ref:       gitRef{Tag: "2.4.0-RC3-AU-JP"},
+			wantTasks: []string{"assembleAustraliaGmsQa", "assembleJapanGmsQa"},
+			wantTags:  []string{"2.4.0-AU-RC3", "2.4.0-JP-RC3"},
+			wantTrace: "regions AU, JP parsed from 2.4.0-RC3-AU-JP",
+		},
+		{
+			name:      "all except qa",
+			ref:       gitRef{Tag: "3.1.0-RC2-ALL-NOSG"},
+			wantTasks: []string{"assembleAustraliaGmsQa"},
+			wantTags:  []string{"3.1.0-AU-RC2"},
+			wantTrace: "region SG excluded by NOSG in 3.1.0-RC2-ALL-NOSG",
+		},
+		{
+			name:    "everything excluded",
+			ref:     gitRef{Tag: "3.1.0-RC2-NOSG-NOAU"},
+			wantErr: true,
+		},
+		{
+			name:      "vendor fan out release",
+			ref:       gitRef{Tag: "2.4.0-AU-GMS-HMS"},
+			wantTasks: []string{"bundleAustraliaGmsRelease", "assembleAustraliaHmsRelease"},
+			wantTags:  []string{"2.4.0-AU-GMS", "2.4.0-AU-HMS"},
+			wantTrace: "vendors GMS, HMS parsed from 2.4.0-AU-GMS-HMS",
+		},
+		{
+			name:      "apk release",
+			ref:       gitRef{Tag: "2.4.0-SG-APK"},
+			wantTasks: []string{"assembleSingaporeGmsRelease"},
+			wantTags:  []string{""},
+			wantTrace: "version 2.4.0 without RC, switching to release build",
+		},
+		{
+			name:      "pr branch",
+			ref:       gitRef{Branch: "develop", IsPR: true},
+			wantTasks: []string{"assembleSingaporeGmsDebug"},
+			wantTags:  []string{""},
+			wantTrace: "PR fallback to default region SG",
+		},
+		{
+			name:    "unknown token in tag",
+			ref:     gitRef{Tag: "1.2.3-AUTO-RC1"},
+			wantErr: true,
+		},
+		{
+			name:      "unknown token in branch",
+			ref:       gitRef{Branch: "JIRA-123"},
+			wantTasks: []string{"assembleSingaporeGmsDebug", "assembleAustraliaGmsDebug"},
+			wantTags:  []string{"", ""},
+			wantTrace: "no region in JIRA-123, building all regions",
+		},
+		{
+			name:      "branch prefix is dropped",
+			ref:       gitRef{Branch: "feature/AU-login"},
+			wantTasks: []string{"assembleAustraliaGmsDebug"},
+			wantTags:  []string{""},
+			wantTrace: "region AU parsed from AU-login",
+		},
+		{
+			name:      "release branch rule",
+			ref:       gitRef{Branch: "release/2.5.0", IsPR: true},
+			wantTasks: []string{"assembleSingaporeGmsQa", "assembleAustraliaGmsQa"},
+			wantTags:  []string{"", ""},
+			wantTrace: "qa build selected by rule release/*",
+		},
+		{
+			name:      "hotfix branch rule",
+			ref:       gitRef{Branch: "hotfix/login"},
+			wantTasks: []string{"assembleSingaporeGmsQa"},
+			wantTags:  []string{""},
+			wantTrace: "no region in login, using regions SG of rule hotfix/*",
+		},
+		{
+			name:    "no ref",
+			ref:     gitRef{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routerCfg := testRouterConfig()
+			var trace routeTrace
+			got, err := generateBuildParams(&routerCfg, tt.ref, &trace)
+			if tt.wantErr {
+				require.Error(t, err, "generateBuildParams() expected to return error")
+				return
+			}
+			require.NoError(t, err, "generateBuildParams() err")
+
+			var tasks, tags []string
+			for _, buildParam := range got {
+				tasks = append(tasks, buildParam.GradleBuildTask)
+				tags = append(tags, buildParam.NewTag)
+				// forked builds check the tag they get
+				if buildParam.NewTag != "" {
+					_, err := parseTagSpec(&routerCfg, buildParam.NewTag, true)
+					require.NoError(t, err, "parseTagSpec() err")
+				}
+			}
+			require.Equal(t, tt.wantTasks, tasks, "generateBuildParams() tasks")
+			require.Equal(t, tt.wantTags, tags, "generateBuildParams() tags")
+			require.Contains(t, trace, tt.wantTrace, "generateBuildParams() trace")
+		})
+	}
+}
+
+func Test_generateBuildParams_eligibility(t *testing.T) {
+	// skip git rev-parse
+	require.NoError(t, os.Setenv("BITRISE_GIT_COMMIT", "abcdef"))
+	defer func() {
+		require.NoError(t, os.Unsetenv("BITRISE_GIT_COMMIT"))
+	}()
+
+	tests := []struct {
+		name      string
+		tag       string
+		wantTasks []string
+		wantTrace string
+		wantErr   bool
+	}{
+		{
+			name:      "region only ships another vendor",
+			tag:       "2.4.0-RC1",
+			wantTasks: []string{"assembleSingaporeGmsQa", "assembleChinaHmsQa"},
+			wantTrace: "region CN only ships HMS",
+		},
+		{
+			name:      "unsupported vendor skipped",
+			tag:       "2.4.0-RC1-GMS",
+			wantTasks: []string{"assembleSingaporeGmsQa"},
+			wantTrace: "skipped: region CN does not ship GMS",
+		},
+		{
+			name:      "unsupported build type skipped",
+			tag:       "2.4.0-HMS",
+			wantTasks: []string{"assembleSingaporeHmsRelease"},
+			wantTrace: "skipped: region CN does not ship release builds",
+		},
+		{
+			name:    "unsupported vendor of explicit region",
+			tag:     "2.4.0-RC1-CN-GMS",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported build type of explicit region",
+			tag:     "2.4.0-CN",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routerCfg := testRouterConfig()
+			routerCfg.Regions = []RegionConfig{
+				{Code: "SG", Name: "singapore", BasePackage: true},
+				{Code: "CN", Name: "china", Vendors: []string{"HMS"}, BuildTypes: []string{"debug", "qa"}},
+			}
+			routerCfg.AllExcludes = nil
+
+			var trace routeTrace
+			got, err := generateBuildParams(&routerCfg, gitRef{Tag: tt.tag}, &trace)
+			if tt.wantErr {
+				require.Error(t, err, "generateBuildParams() expected to return error")
+				return
+			}
+			require.NoError(t, err, "generateBuildParams() err")
+
+			var tasks []string
+			for _, buildParam := range got {
+				tasks = append(tasks, buildParam.GradleBuildTask)
+			}
+			require.Equal(t, tt.wantTasks, tasks, "generateBuildParams() tasks")
+			require.Contains(t, trace, tt.wantTrace, "generateBuildParams() trace")
+		})
+	}
+}
+
+func Test_generatePackageName(t *testing.T) {
+	tests := []struct {
+		name         string
+		template     string
+		region       RegionConfig
+		vendor       string
+		buildType    BuildType
+		vendorSuffix string
+		want         string
+		wantErr      bool
+	}{
+		{
+			name:      "default base package",
+			region:    RegionConfig{Code: "SG", Name: "singapore", BasePackage: true},
+			vendor:    "GMS",
+			buildType: Release,
+			want:      "com.circles.selfcare",
+		},
+		{
+			name:      "default qa",
+			region:    RegionConfig{Code: "AU", Name: "australia"},
+			vendor:    "GMS",
+			buildType: Qa,
+			want:      "com.circles.selfcare.au.qa",
+		},
+		{
+			name:         "default vendor suffix",
+			region:       RegionConfig{Code: "AU", Name: "australia"},
+			vendor:       "HMS",
+			buildType:    Debug,
+			vendorSuffix: ".huawei",
+			want:         "com.circles.selfcare.au.huawei.debug",
+		},
+		{
+			name:      "custom template",
+			template:  `{{.Base}}.{{lower .A2}}{{if ne .BuildType "release"}}.{{.BuildType}}{{end}}`,
+			region:    RegionConfig{Code: "SG", Name: "singapore", BasePackage: true},
+			vendor:    "GMS",
+			buildType: Qa,
+			want:      "com.circles.selfcare.sg.qa",
+		},
+		{
+			name:      "region override",
+			region:    RegionConfig{Code: "XX", Name: "whitelabel", PackageTemplate: "com.partner.{{lower .Vendor}}"},
+			vendor:    "HMS",
+			buildType: Release,
+			want:      "com.partner.hms",
+		},
+		{
+			name:      "unknown field",
+			template:  "{{.Base}}.{{.Flavor}}",
+			region:    RegionConfig{Code: "AU", Name: "australia"},
+			vendor:    "GMS",
+			buildType: Release,
+			wantErr:   true,
+		},
+		{
+			name:      "invalid package name",
+			template:  "{{.Base}}.{{.A2}}-app",
+			region:    RegionConfig{Code: "AU", Name: "australia"},
+			vendor:    "GMS",
+			buildType: Release,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routerCfg := testRouterConfig()
+			if tt.template != "" {
+				routerCfg.Naming.PackageTemplate = tt.template
+			}
+			if tt.vendorSuffix != "" {
+				routerCfg.Naming.VendorPackageSuffixes = map[string]string{tt.vendor: tt.vendorSuffix}
+			}
+
+			got, err := generatePackageName(&routerCfg, tt.region, tt.region.Code, tt.vendor, &tt.buildType)
+			if tt.wantErr {
+				require.Error(t, err, "generatePackageName() expected to return error")
+				return
+			}
+			require.NoError(t, err, "generatePackageName() err")
+			require.Equal(t, tt.want, got, "generatePackageName()")
+		})
+	}
+}
+
+func Test_generateGradleTasks(t *testing.T) {
+	data := gradleTaskData{Command: "bundle", Region: "australia", Code: "AU", A2: "au", Vendor: "GMS", BuildType: "release"}
+
+	tests := []struct {
+		name      string
+		templates []string
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "default build task",
+			templates: defaultBuildTaskTemplates,
+			want:      "bundleAustraliaGmsRelease",
+		},
+		{
+			name:      "default test task",
+			templates: defaultTestTaskTemplates,
+			want:      "testAustraliaGmsReleaseUnitTest",
+		},
+		{
+			name: "module paths, custom order and multiple tasks",
+			templates: []string{
+				`:app:{{camel .Command (lower .Vendor) .Region .BuildType}}`,
+				`:accmng:{{camel "lint" .Region .BuildType}}`,
+			},
+			want: ":app:bundleGmsAustraliaRelease :accmng:lintAustraliaRelease",
+		},
+		{
+			name:      "empty task",
+			templates: []string{`{{if eq .Vendor "HMS"}}assembleHuawei{{end}}`},
+			wantErr:   true,
+		},
+		{
+			name:      "broken template",
+			templates: []string{`{{camel .Command`},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := generateGradleTasks(tt.templates, data)
+			if tt.wantErr {
+				require.Error(t, err, "generateGradleTasks() expected to return error")
+				return
+			}
+			require.NoError(t, err, "generateGradleTasks() err")
+			require.Equal(t, tt.want, got, "generateGradleTasks()")
+		})
+	}
+}
+
+func Test_generateBuildParams_version(t *testing.T) {
+	// skip git rev-parse
+	require.NoError(t, os.Setenv("BITRISE_GIT_COMMIT", "abcdef"))
+	defer func() {
+		require.NoError(t, os.Unsetenv("BITRISE_GIT_COMMIT"))
+	}()
+
+	tests := []struct {
+		name          string
+		tag           string
+		config        func(rc *RouterConfig)
+		wantBuildType BuildType
+		wantVersion   []string
+	}{
+		{
+			name:          "release",
+			tag:           "v2.3.1-AU",
+			wantBuildType: Release,
+			wantVersion:   []string{"2.3.1", "2", "3", "1", ""},
+		},
+		{
+			name:          "prerelease is not a release",
+			tag:           "2.3.1-beta.2-AU",
+			wantBuildType: Qa,
+			wantVersion:   []string{"2.3.1-beta.2", "2", "3", "1", "beta.2"},
+		},
+		{
+			name:          "rc prerelease is not a release",
+			tag:           "2.3.1-rc.1-AU",
+			wantBuildType: Qa,
+			wantVersion:   []string{"2.3.1-rc.1", "2", "3", "1", "rc.1"},
+		},
+		{
+			name:          "metadata is not part of the name",
+			tag:           "2.3.1.4+45-AU-RC2",
+			wantBuildType: Qa,
+			wantVersion:   []string{"2.3.1.4", "2", "3", "1", ""},
+		},
+		{
+			name:          "marker selects a registered build type",
+			tag:           "v2.3.1-AU-STG",
+			wantBuildType: "staging",
+			wantVersion:   []string{"2.3.1", "2", "3", "1", ""},
+		},
+		{
+			name: "marker wins over the prerelease pattern",
+			tag:  "2.3.1-BETA-AU",
+			config: func(rc *RouterConfig) {
+				rc.BuildTypes["beta"] = BuildTypeConfig{ID: 4, GradleName: "beta", Marker: "BETA", PackageSuffix: ".beta"}
+				rc.Tag.PrereleasePattern = `(?i)(alpha|dev|preview|rc)(\.?\d+)*`
+			},
+			wantBuildType: "beta",
+			wantVersion:   []string{"2.3.1", "2", "3", "1", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routerCfg := testRouterConfig()
+			routerCfg.BuildTypes["staging"] = BuildTypeConfig{ID: 3, GradleName: "stg", Marker: "STG", PackageSuffix: ".stg"}
+			if tt.config != nil {
+				tt.config(&routerCfg)
+			}
+			require.NoError(t, routerCfg.validate(), "validate() err")
+			var trace routeTrace
+			got, err := generateBuildParams(&routerCfg, gitRef{Tag: tt.tag}, &trace)
+			require.NoError(t, err, "generateBuildParams() err")
+			require.Len(t, got, 1)
+
+			params := got[0]
+			require.Equal(t, tt.wantBuildType.Name(), params.BuildTypeName, "generateBuildParams() build type")
+			require.Equal(t, routerCfg.BuildTypes[params.BuildTypeName].ID, params.TgtBuildType, "generateBuildParams() build type id")
+			require.Equal(t, tt.wantVersion, []string{params.VersionName, params.VersionMajor, params.VersionMinor, params.VersionPatch, params.VersionPrerelease}, "generateBuildParams() version")
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// versionExp matches v2.3.1, 2.3.1.4 and 2.3.1+45, the prerelease is a separate tag token
+var versionExp = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:\.(0|[1-9]\d*))?(?:\+([0-9A-Za-z.]+))?$`)
+
+// versionLikeExp matches tokens meant to be a version, which have to parse or the tag is rejected
+var versionLikeExp = regexp.MustCompile(`^v?\d+\.`)
+
+// Version is a semantic version parsed from a tag
+type Version struct {
+	Major       int    `json:"major"`
+	Minor       int    `json:"minor"`
+	Patch       int    `json:"patch"`
+	Revision    int    `json:"revision,omitempty"`   // fourth component, e.g. 2.3.1.4
+	HasRevision bool   `json:"has_revision"`         // tells 2.3.1.0 and 2.3.1 apart
+	Prerelease  string `json:"prerelease,omitempty"` // e.g. beta.2
+	Metadata    string `json:"metadata,omitempty"`   // e.g. 45 of 2.3.1+45
+}
+
+// parseVersion parses a version token, without its prerelease
+func parseVersion(token string) (Version, error) {
+	match := versionExp.FindStringSubmatch(token)
+	if match == nil {
+		return Version{}, fmt.Errorf("malformed version %s, expected MAJOR.MINOR.PATCH[.REVISION][+METADATA]", token)
+	}
+
+	var v Version
+	for i, dst := range []*int{&v.Major, &v.Minor, &v.Patch, &v.Revision} {
+		if match[i+1] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(match[i+1])
+		if err != nil {
+			return Version{}, fmt.Errorf("malformed version %s: %s", token, err)
+		}
+		*dst = n
+	}
+	v.HasRevision = match[4] != ""
+	v.Metadata = match[5]
+	return v, nil
+}
+
+// Name returns the version name, e.g. 2.3.1-beta.2, without the metadata
+func (v Version) Name() string {
+	name := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.HasRevision {
+		name = fmt.Sprintf("%s.%d", name, v.Revision)
+	}
+	if v.Prerelease != "" {
+		name = name + "-" + v.Prerelease
+	}
+	return name
+}
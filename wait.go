@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vielasis/bitrise-step-build-router-start/bitrise"
+)
+
+const envChildResults = "ROUTER_CHILD_RESULTS"
+
+const (
+	abortPolicyWaitAll     = "wait-all"
+	abortPolicyFailFast    = "fail-fast"
+	abortPolicyAfterPrefix = "abort-after-"
+)
+
+// abortPolicy tells after how many failed forked builds the remaining ones are aborted
+type abortPolicy struct {
+	MaxFailures int // 0 waits for every build
+}
+
+// parseAbortPolicy parses wait-all, fail-fast or abort-after-N, e.g. abort-after-2
+func parseAbortPolicy(s string) (abortPolicy, error) {
+	switch {
+	case s == "" || s == abortPolicyWaitAll:
+		return abortPolicy{}, nil
+	case s == abortPolicyFailFast:
+		return abortPolicy{MaxFailures: 1}, nil
+	case strings.HasPrefix(s, abortPolicyAfterPrefix):
+		n, err := strconv.Atoi(strings.TrimPrefix(s, abortPolicyAfterPrefix))
+		if err != nil || n < 1 {
+			return abortPolicy{}, fmt.Errorf("invalid abort policy %s, expected a positive number of failures", s)
+		}
+		return abortPolicy{MaxFailures: n}, nil
+	default:
+		return abortPolicy{}, fmt.Errorf("unknown abort policy %s, expected %s, %s or %sN", s, abortPolicyWaitAll, abortPolicyFailFast, abortPolicyAfterPrefix)
+	}
+}
+
+// parseWaitTimeout parses a Go duration, e.g. 90m, an empty timeout waits until every build finished
+func parseWaitTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(s)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid wait timeout %s, expected a duration like 90m", s)
+	}
+	return timeout, nil
+}
+
+// shouldAbort reports whether the remaining builds are aborted after the given number of failed builds
+func (policy abortPolicy) shouldAbort(failures int) bool {
+	return policy.MaxFailures > 0 && failures >= policy.MaxFailures
+}
+
+// abortReason describes why the remaining builds are aborted, e.g. "aborted by router: AU HMS failed in build #1234"
+func abortReason(failed *childBuild) string {
+	return fmt.Sprintf("aborted by router: %s failed in build #%d", joinIgnoreEmpty([]string{failed.A2Code, failed.Vendor}, " "), failed.BuildNumber)
+}
+
+// childBuild is a forked build and its last known status
+type childBuild struct {
+	Region          string              `json:"region"`
+	A2Code          string              `json:"alpha_2_code"`
+	Vendor          string              `json:"vendor"`
+	BuildType       string              `json:"build_type"`
+	BuildSlug       string              `json:"build_slug"`
+	BuildNumber     int64               `json:"build_number"`
+	BuildURL        string              `json:"build_url"`
+	Status          bitrise.BuildStatus `json:"status"`
+	StatusText      string              `json:"status_text"`
+	QueueSeconds    int64               `json:"queue_seconds,omitempty"`
+	DurationSeconds int64               `json:"duration_seconds,omitempty"`
+	TimedOut        bool                `json:"timed_out,omitempty"` // still running when the wait timed out
+	Flag            string              `json:"-"`                   // Slack
+}
+
+// finished reports whether the build is no longer running
+func (child childBuild) finished() bool {
+	return child.Status.Finished()
+}
+
+// succeeded reports whether the build finished successfully
+func (child childBuild) succeeded() bool {
+	return child.Status.Succeeded()
+}
+
+// childBuilds are the builds forked by the parent, in the order they were started
+type childBuilds []*childBuild
+
+func (children *childBuilds) add(buildParam BuildParams, started bitrise.StartResponse) {
+	*children = append(*children, &childBuild{
+		Region:      buildParam.BuildRegion,
+		A2Code:      buildParam.Alpha2Code,
+		Vendor:      buildParam.VendorService,
+		BuildType:   buildParam.BuildTypeName,
+		Flag:        buildParam.SlackFlag,
+		BuildSlug:   started.BuildSlug,
+		BuildNumber: int64(started.BuildNumber),
+		BuildURL:    fmt.Sprintf("https://app.bitrise.io/build/%s", started.BuildSlug),
+	})
+}
+
+func (children childBuilds) slugs() []string {
+	var slugs []string
+	for _, child := range children {
+		slugs = append(slugs, child.BuildSlug)
+	}
+	return slugs
+}
+
+// update records the status of a polled build, returning its child
+func (children childBuilds) update(build bitrise.Build) *childBuild {
+	for _, child := range children {
+		if child.BuildSlug != build.Slug {
+			continue
+		}
+		child.BuildNumber = build.BuildNumber
+		child.Status = build.Status
+		child.StatusText = build.StatusText
+		return child
+	}
+	return nil
+}
+
+// record stores the durations and timeouts of the wait result
+func (children childBuilds) record(result bitrise.WaitResult) {
+	for _, build := range result.Builds {
+		for _, child := range children {
+			if child.BuildSlug != build.Slug {
+				continue
+			}
+			child.QueueSeconds = int64(build.QueueDuration.Seconds())
+			child.DurationSeconds = int64(build.Duration.Seconds())
+			child.TimedOut = build.TimedOut
+		}
+	}
+}
+
+// timedOut returns the children which were still running when the wait timed out
+func (children childBuilds) timedOut() childBuilds {
+	var timedOut childBuilds
+	for _, child := range children {
+		if child.TimedOut {
+			timedOut = append(timedOut, child)
+		}
+	}
+	return timedOut
+}
+
+// running returns the children which did not finish yet
+func (children childBuilds) running() childBuilds {
+	var running childBuilds
+	for _, child := range children {
+		if !child.finished() {
+			running = append(running, child)
+		}
+	}
+	return running
+}
+
+// failed returns the children which finished without success
+func (children childBuilds) failed() childBuilds {
+	var failed childBuilds
+	for _, child := range children {
+		if child.finished() && !child.succeeded() {
+			failed = append(failed, child)
+		}
+	}
+	return failed
+}
+
+// summary returns the results as exported to ROUTER_CHILD_RESULTS
+func (children childBuilds) summary() (string, error) {
+	if children == nil {
+		children = childBuilds{}
+	}
+	b, err := json.Marshal(children)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
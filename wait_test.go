@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vielasis/bitrise-step-build-router-start/bitrise"
+)
+
+func Test_childBuilds_update(t *testing.T) {
+	tests := []struct {
+		name        string
+		builds      []bitrise.Build
+		wantFailed  []string
+		wantSummary string
+	}{
+		{
+			name:        "running",
+			builds:      []bitrise.Build{{Slug: "au", Status: 0, StatusText: "in-progress", BuildNumber: 12}},
+			wantSummary: `[{"region":"Australia","alpha_2_code":"au","vendor":"GMS","build_type":"qa","build_slug":"au","build_number":12,"build_url":"https://app.bitrise.io/build/au","status":0,"status_text":"in-progress"},{"region":"Japan","alpha_2_code":"JP","vendor":"GMS","build_type":"qa","build_slug":"jp","build_number":11,"build_url":"https://app.bitrise.io/build/jp","status":0,"status_text":""}]`,
+		},
+		{
+			name: "one failed",
+			builds: []bitrise.Build{
+				{Slug: "au", Status: 0, StatusText: "in-progress", BuildNumber: 12},
+				{Slug: "jp", Status: 2, StatusText: "error", BuildNumber: 11},
+				{Slug: "au", Status: 1, StatusText: "success", BuildNumber: 12},
+			},
+			wantFailed:  []string{"jp"},
+			wantSummary: `[{"region":"Australia","alpha_2_code":"au","vendor":"GMS","build_type":"qa","build_slug":"au","build_number":12,"build_url":"https://app.bitrise.io/build/au","status":1,"status_text":"success"},{"region":"Japan","alpha_2_code":"JP","vendor":"GMS","build_type":"qa","build_slug":"jp","build_number":11,"build_url":"https://app.bitrise.io/build/jp","status":2,"status_text":"error"}]`,
+		},
+		{
+			name:        "aborted",
+			builds:      []bitrise.Build{{Slug: "au", Status: 3, StatusText: "aborted", BuildNumber: 12}},
+			wantFailed:  []string{"au"},
+			wantSummary: `[{"region":"Australia","alpha_2_code":"au","vendor":"GMS","build_type":"qa","build_slug":"au","build_number":12,"build_url":"https://app.bitrise.io/build/au","status":3,"status_text":"aborted"},{"region":"Japan","alpha_2_code":"JP","vendor":"GMS","build_type":"qa","build_slug":"jp","build_number":11,"build_url":"https://app.bitrise.io/build/jp","status":0,"status_text":""}]`,
+		},
+		{
+			name:        "aborted with success",
+			builds:      []bitrise.Build{{Slug: "au", Status: 4, StatusText: "aborted-with-success", BuildNumber: 12}},
+			wantSummary: `[{"region":"Australia","alpha_2_code":"au","vendor":"GMS","build_type":"qa","build_slug":"au","build_number":12,"build_url":"https://app.bitrise.io/build/au","status":4,"status_text":"aborted-with-success"},{"region":"Japan","alpha_2_code":"JP","vendor":"GMS","build_type":"qa","build_slug":"jp","build_number":11,"build_url":"https://app.bitrise.io/build/jp","status":0,"status_text":""}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var children childBuilds
+			children.add(BuildParams{BuildRegion: "Australia", Alpha2Code: "au", VendorService: "GMS", BuildTypeName: "qa"}, bitrise.StartResponse{BuildSlug: "au", BuildNumber: 12})
+			children.add(BuildParams{BuildRegion: "Japan", Alpha2Code: "JP", VendorService: "GMS", BuildTypeName: "qa"}, bitrise.StartResponse{BuildSlug: "jp", BuildNumber: 11})
+
+			for _, build := range tt.builds {
+				require.NotNil(t, children.update(build), "childBuilds.update() child")
+			}
+
+			var failed []string
+			for _, child := range children.failed() {
+				failed = append(failed, child.BuildSlug)
+			}
+			require.Equal(t, tt.wantFailed, failed, "childBuilds.failed()")
+
+			summary, err := children.summary()
+			require.NoError(t, err, "childBuilds.summary() err")
+			require.Equal(t, tt.wantSummary, summary, "childBuilds.summary()")
+		})
+	}
+}
+
+func Test_parseAbortPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    string
+		failures  int
+		wantAbort bool
+		wantErr   bool
+	}{
+		{name: "default waits for all", policy: "", failures: 3, wantAbort: false},
+		{name: "wait all", policy: "wait-all", failures: 3, wantAbort: false},
+		{name: "fail fast", policy: "fail-fast", failures: 1, wantAbort: true},
+		{name: "abort after 2, first failure", policy: "abort-after-2", failures: 1, wantAbort: false},
+		{name: "abort after 2, second failure", policy: "abort-after-2", failures: 2, wantAbort: true},
+		{name: "abort after 0", policy: "abort-after-0", wantErr: true},
+		{name: "abort after nothing", policy: "abort-after-", wantErr: true},
+		{name: "unknown", policy: "fast", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAbortPolicy(tt.policy)
+			if tt.wantErr {
+				require.Error(t, err, "parseAbortPolicy() expected to return error")
+				return
+			}
+			require.NoError(t, err, "parseAbortPolicy() err")
+			require.Equal(t, tt.wantAbort, got.shouldAbort(tt.failures), "abortPolicy.shouldAbort()")
+		})
+	}
+}
+
+func Test_parseWaitTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "no timeout", timeout: "", want: 0},
+		{name: "minutes", timeout: "90m", want: 90 * time.Minute},
+		{name: "hours and minutes", timeout: "1h30m", want: 90 * time.Minute},
+		{name: "no unit", timeout: "90", wantErr: true},
+		{name: "negative", timeout: "-5m", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseWaitTimeout(tt.timeout)
+			if tt.wantErr {
+				require.Error(t, err, "parseWaitTimeout() expected to return error")
+				return
+			}
+			require.NoError(t, err, "parseWaitTimeout() err")
+			require.Equal(t, tt.want, got, "parseWaitTimeout()")
+		})
+	}
+}
+
+func Test_abortReason(t *testing.T) {
+	tests := []struct {
+		name  string
+		child childBuild
+		want  string
+	}{
+		{name: "vendor", child: childBuild{A2Code: "AU", Vendor: "HMS", BuildNumber: 1234}, want: "aborted by router: AU HMS failed in build #1234"},
+		{name: "no vendor", child: childBuild{A2Code: "AU", BuildNumber: 1234}, want: "aborted by router: AU failed in build #1234"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, abortReason(&tt.child), "abortReason()")
+		})
+	}
+}